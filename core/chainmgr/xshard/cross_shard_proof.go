@@ -0,0 +1,611 @@
+/*
+ * Copyright (C) 2019 The ontology Authors
+ * This file is part of The ontology library.
+ *
+ * The ontology is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The ontology is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public License
+ * along with The ontology.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package xshard
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/ontio/ontology-crypto/keypair"
+	"github.com/ontio/ontology/common"
+	"github.com/ontio/ontology/common/log"
+	"github.com/ontio/ontology/common/serialization"
+	"github.com/ontio/ontology/core/ledger"
+	"github.com/ontio/ontology/core/signature"
+	"github.com/ontio/ontology/core/types"
+)
+
+// ShardHeaderSummary is the minimal per-source-shard header info needed to
+// verify a CrossShardMsgProof without downloading full source-shard state:
+// the msg-root it commits to, plus enough linkage/signature info to detect
+// equivocation and reject headers from outside the source shard's own
+// consensus bookkeeper set.
+type ShardHeaderSummary struct {
+	ShardID        common.ShardID
+	Height         uint32
+	ParentHash     common.Uint256 // MsgRoot of the header at Height-1
+	MsgRoot        common.Uint256 // CrossShardMsgRoot committed at Height
+	Bookkeepers    []keypair.PublicKey // signer set attesting to this header, in BookkeeperSigs order
+	BookkeeperSigs [][]byte            // one consensus signature per entry in Bookkeepers
+}
+
+// signingDigest is the byte string every bookkeeper signature in
+// BookkeeperSigs must cover: the header's identity (shard, height) and its
+// two commitments (parent linkage, msg root).
+func (hdr *ShardHeaderSummary) signingDigest() []byte {
+	buf := new(bytes.Buffer)
+	shardID := hdr.ShardID.ToUint64()
+	height := hdr.Height
+	for i := 0; i < 8; i++ {
+		buf.WriteByte(byte(shardID >> (8 * uint(i))))
+	}
+	for i := 0; i < 4; i++ {
+		buf.WriteByte(byte(height >> (8 * uint(i))))
+	}
+	buf.Write(hdr.ParentHash[:])
+	buf.Write(hdr.MsgRoot[:])
+	return buf.Bytes()
+}
+
+// Serialize/Deserialize give ShardHeaderSummary a binary wire form so a
+// HeaderChain can be persisted to disk (see NewFileHeaderStore) and
+// rehydrated across a restart, the same way Record's wire form lets the
+// keeper store (core/chainmgr/xshard/keeper/store.go) survive one.
+func (hdr *ShardHeaderSummary) Serialize(w io.Writer) error {
+	if err := hdr.ShardID.Serialize(w); err != nil {
+		return fmt.Errorf("serialize ShardHeaderSummary.ShardID: %s", err)
+	}
+	if err := serialization.WriteUint32(w, hdr.Height); err != nil {
+		return fmt.Errorf("serialize ShardHeaderSummary.Height: %s", err)
+	}
+	if err := hdr.ParentHash.Serialize(w); err != nil {
+		return fmt.Errorf("serialize ShardHeaderSummary.ParentHash: %s", err)
+	}
+	if err := hdr.MsgRoot.Serialize(w); err != nil {
+		return fmt.Errorf("serialize ShardHeaderSummary.MsgRoot: %s", err)
+	}
+	if err := serialization.WriteUint32(w, uint32(len(hdr.Bookkeepers))); err != nil {
+		return fmt.Errorf("serialize ShardHeaderSummary.Bookkeepers length: %s", err)
+	}
+	for i, pk := range hdr.Bookkeepers {
+		if err := serialization.WriteVarBytes(w, keypair.SerializePublicKey(pk)); err != nil {
+			return fmt.Errorf("serialize ShardHeaderSummary.Bookkeepers[%d]: %s", i, err)
+		}
+	}
+	if err := serialization.WriteUint32(w, uint32(len(hdr.BookkeeperSigs))); err != nil {
+		return fmt.Errorf("serialize ShardHeaderSummary.BookkeeperSigs length: %s", err)
+	}
+	for i, sig := range hdr.BookkeeperSigs {
+		if err := serialization.WriteVarBytes(w, sig); err != nil {
+			return fmt.Errorf("serialize ShardHeaderSummary.BookkeeperSigs[%d]: %s", i, err)
+		}
+	}
+	return nil
+}
+
+func (hdr *ShardHeaderSummary) Deserialize(r io.Reader) error {
+	if err := hdr.ShardID.Deserialize(r); err != nil {
+		return fmt.Errorf("deserialize ShardHeaderSummary.ShardID: %s", err)
+	}
+	height, err := serialization.ReadUint32(r)
+	if err != nil {
+		return fmt.Errorf("deserialize ShardHeaderSummary.Height: %s", err)
+	}
+	hdr.Height = height
+	if err := hdr.ParentHash.Deserialize(r); err != nil {
+		return fmt.Errorf("deserialize ShardHeaderSummary.ParentHash: %s", err)
+	}
+	if err := hdr.MsgRoot.Deserialize(r); err != nil {
+		return fmt.Errorf("deserialize ShardHeaderSummary.MsgRoot: %s", err)
+	}
+	bkCount, err := serialization.ReadUint32(r)
+	if err != nil {
+		return fmt.Errorf("deserialize ShardHeaderSummary.Bookkeepers length: %s", err)
+	}
+	hdr.Bookkeepers = make([]keypair.PublicKey, bkCount)
+	for i := uint32(0); i < bkCount; i++ {
+		raw, err := serialization.ReadVarBytes(r)
+		if err != nil {
+			return fmt.Errorf("deserialize ShardHeaderSummary.Bookkeepers[%d]: %s", i, err)
+		}
+		pk, err := keypair.DeserializePublicKey(raw)
+		if err != nil {
+			return fmt.Errorf("deserialize ShardHeaderSummary.Bookkeepers[%d]: %s", i, err)
+		}
+		hdr.Bookkeepers[i] = pk
+	}
+	sigCount, err := serialization.ReadUint32(r)
+	if err != nil {
+		return fmt.Errorf("deserialize ShardHeaderSummary.BookkeeperSigs length: %s", err)
+	}
+	hdr.BookkeeperSigs = make([][]byte, sigCount)
+	for i := uint32(0); i < sigCount; i++ {
+		sig, err := serialization.ReadVarBytes(r)
+		if err != nil {
+			return fmt.Errorf("deserialize ShardHeaderSummary.BookkeeperSigs[%d]: %s", i, err)
+		}
+		hdr.BookkeeperSigs[i] = sig
+	}
+	return nil
+}
+
+// HeaderStore persists ShardHeaderSummary rows so a HeaderChain survives a
+// restart instead of resetting to empty. fileHeaderStore is the only
+// implementation; it mirrors keeper's fileStore (atomic write-then-rename
+// per record, replayed back via List on construction).
+type HeaderStore interface {
+	Put(hdr *ShardHeaderSummary) error
+	List(shardID common.ShardID) []*ShardHeaderSummary
+}
+
+type fileHeaderStore struct {
+	lock    sync.RWMutex
+	baseDir string
+}
+
+// NewFileHeaderStore opens (creating if necessary) a header store rooted at
+// baseDir. Unlike keeper's fileStore it keeps no in-process cache of its
+// own: HeaderChain.headers already is that cache, so fileHeaderStore only
+// needs to persist writes; List replays them back once, at construction.
+func NewFileHeaderStore(baseDir string) (HeaderStore, error) {
+	if err := os.MkdirAll(baseDir, 0750); err != nil {
+		return nil, fmt.Errorf("NewFileHeaderStore: create %s: %s", baseDir, err)
+	}
+	return &fileHeaderStore{baseDir: baseDir}, nil
+}
+
+func headerFileName(shardID common.ShardID, height uint32) string {
+	return fmt.Sprintf("%d-%d.hdr", shardID.ToUint64(), height)
+}
+
+func (s *fileHeaderStore) Put(hdr *ShardHeaderSummary) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	buf := new(bytes.Buffer)
+	if err := hdr.Serialize(buf); err != nil {
+		return fmt.Errorf("header file store: encode header: %s", err)
+	}
+	path := filepath.Join(s.baseDir, headerFileName(hdr.ShardID, hdr.Height))
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, buf.Bytes(), 0640); err != nil {
+		return fmt.Errorf("header file store: write %s: %s", tmp, err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("header file store: rename %s: %s", tmp, err)
+	}
+	return nil
+}
+
+func (s *fileHeaderStore) List(shardID common.ShardID) []*ShardHeaderSummary {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	entries, err := ioutil.ReadDir(s.baseDir)
+	if err != nil {
+		log.Errorf("header file store: read %s: %s", s.baseDir, err)
+		return nil
+	}
+	prefix := fmt.Sprintf("%d-", shardID.ToUint64())
+	result := make([]*ShardHeaderSummary, 0)
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != ".hdr" || !strings.HasPrefix(entry.Name(), prefix) {
+			continue
+		}
+		payload, err := ioutil.ReadFile(filepath.Join(s.baseDir, entry.Name()))
+		if err != nil {
+			log.Errorf("header file store: read %s: %s", entry.Name(), err)
+			continue
+		}
+		hdr := &ShardHeaderSummary{}
+		if err := hdr.Deserialize(bytes.NewBuffer(payload)); err != nil {
+			log.Errorf("header file store: decode %s: %s", entry.Name(), err)
+			continue
+		}
+		result = append(result, hdr)
+	}
+	return result
+}
+
+// CrossShardMsgProof is a compact Merkle inclusion proof that a message's
+// PreCrossShardMsgHash is linked into the CrossShardMsgRoot committed by a
+// source-shard header, mirroring the ODR proof used by Ethereum light clients.
+type CrossShardMsgProof struct {
+	LeafHash    common.Uint256   // leaf being proven: a msg's PreCrossShardMsgHash, or an encoded event's hash
+	SiblingPath []common.Uint256 // sibling hashes from leaf to root, bottom-up
+	PathBits    uint64           // per-level left(0)/right(1) bit, LSB = leaf level
+	Height      uint32           // source-shard height that committed Root
+	Root        common.Uint256   // expected CrossShardMsgRoot at Height
+}
+
+// Serialize/Deserialize give CrossShardMsgProof a binary wire form so it can
+// travel inside a WithdrawGasClaimEvent.LockProof (see
+// DecodeCrossShardMsgProof in gas_withdraw.go), the same way Record's wire
+// form lets the keeper store persist to disk.
+func (p *CrossShardMsgProof) Serialize(w io.Writer) error {
+	if err := p.LeafHash.Serialize(w); err != nil {
+		return fmt.Errorf("serialize CrossShardMsgProof.LeafHash: %s", err)
+	}
+	if err := serialization.WriteUint32(w, uint32(len(p.SiblingPath))); err != nil {
+		return fmt.Errorf("serialize CrossShardMsgProof.SiblingPath length: %s", err)
+	}
+	for i, sibling := range p.SiblingPath {
+		if err := sibling.Serialize(w); err != nil {
+			return fmt.Errorf("serialize CrossShardMsgProof.SiblingPath[%d]: %s", i, err)
+		}
+	}
+	if err := serialization.WriteUint64(w, p.PathBits); err != nil {
+		return fmt.Errorf("serialize CrossShardMsgProof.PathBits: %s", err)
+	}
+	if err := serialization.WriteUint32(w, p.Height); err != nil {
+		return fmt.Errorf("serialize CrossShardMsgProof.Height: %s", err)
+	}
+	if err := p.Root.Serialize(w); err != nil {
+		return fmt.Errorf("serialize CrossShardMsgProof.Root: %s", err)
+	}
+	return nil
+}
+
+func (p *CrossShardMsgProof) Deserialize(r io.Reader) error {
+	if err := p.LeafHash.Deserialize(r); err != nil {
+		return fmt.Errorf("deserialize CrossShardMsgProof.LeafHash: %s", err)
+	}
+	n, err := serialization.ReadUint32(r)
+	if err != nil {
+		return fmt.Errorf("deserialize CrossShardMsgProof.SiblingPath length: %s", err)
+	}
+	p.SiblingPath = make([]common.Uint256, n)
+	for i := uint32(0); i < n; i++ {
+		if err := p.SiblingPath[i].Deserialize(r); err != nil {
+			return fmt.Errorf("deserialize CrossShardMsgProof.SiblingPath[%d]: %s", i, err)
+		}
+	}
+	if p.PathBits, err = serialization.ReadUint64(r); err != nil {
+		return fmt.Errorf("deserialize CrossShardMsgProof.PathBits: %s", err)
+	}
+	if p.Height, err = serialization.ReadUint32(r); err != nil {
+		return fmt.Errorf("deserialize CrossShardMsgProof.Height: %s", err)
+	}
+	if err := p.Root.Deserialize(r); err != nil {
+		return fmt.Errorf("deserialize CrossShardMsgProof.Root: %s", err)
+	}
+	return nil
+}
+
+// BookkeeperSetProvider resolves the source shard's authorized consensus
+// bookkeeper set at height, so HeaderChain.AddHeader can reject headers
+// signed by keys outside that set instead of trusting any self-declared
+// signer.
+type BookkeeperSetProvider func(shardID common.ShardID, height uint32) ([]keypair.PublicKey, error)
+
+// ShardBookkeeperProvider resolves the authorized bookkeeper set for a source
+// shard. It must be wired up during node startup (typically from the
+// shard's own ConfigShardEvent peer list) before any header from that shard
+// can be trusted; until it is set, AddHeader rejects every header.
+var ShardBookkeeperProvider BookkeeperSetProvider
+
+// HeaderChain is a lightweight, per-source-shard chain of ShardHeaderSummary
+// entries, each validated against the source shard's bookkeeper set and
+// linked to its parent on entry. It lets a node bootstrap cross-shard traffic
+// from untrusted relays and verify CrossShardMsgProofs without syncing the
+// source shard's full state.
+type HeaderChain struct {
+	lock        sync.RWMutex
+	ShardID     common.ShardID
+	headers     map[uint32]*ShardHeaderSummary
+	bookkeepers BookkeeperSetProvider
+	store       HeaderStore
+}
+
+func NewHeaderChain(shardID common.ShardID, bookkeepers BookkeeperSetProvider) *HeaderChain {
+	return &HeaderChain{
+		ShardID:     shardID,
+		headers:     make(map[uint32]*ShardHeaderSummary),
+		bookkeepers: bookkeepers,
+	}
+}
+
+// NewPersistentHeaderChain is NewHeaderChain plus a HeaderStore: every header
+// AddHeader accepts from here on is written through to store before it is
+// cached, and whatever store already holds for shardID is loaded immediately
+// so equivocation detection resumes across a restart instead of trusting
+// whatever header a relay hands it first.
+func NewPersistentHeaderChain(shardID common.ShardID, bookkeepers BookkeeperSetProvider, store HeaderStore) *HeaderChain {
+	chain := NewHeaderChain(shardID, bookkeepers)
+	chain.store = store
+	for _, hdr := range store.List(shardID) {
+		chain.headers[hdr.Height] = hdr
+	}
+	return chain
+}
+
+// AddHeader validates that hdr carries a quorum of valid signatures from the
+// source shard's current bookkeeper set, that it links to the header already
+// stored at Height-1 (a header above height 0 with no stored parent is
+// rejected), and that it does not conflict with
+// a header already accepted at Height, before appending it to the chain. A
+// mismatched linkage or root indicates source-shard equivocation.
+func (chain *HeaderChain) AddHeader(hdr *ShardHeaderSummary) error {
+	chain.lock.Lock()
+	defer chain.lock.Unlock()
+
+	if err := chain.verifySignaturesLocked(hdr); err != nil {
+		return err
+	}
+
+	if hdr.Height > 0 {
+		parent, present := chain.headers[hdr.Height-1]
+		if !present {
+			return fmt.Errorf("header chain: shard %v height %d: no parent header at height %d", chain.ShardID, hdr.Height, hdr.Height-1)
+		}
+		if parent.MsgRoot != hdr.ParentHash {
+			return fmt.Errorf("header chain: shard %v height %d: parent hash %s does not link to stored header %s",
+				chain.ShardID, hdr.Height, hdr.ParentHash.ToHexString(), parent.MsgRoot.ToHexString())
+		}
+	}
+
+	if prev, present := chain.headers[hdr.Height]; present {
+		if prev.MsgRoot != hdr.MsgRoot {
+			return fmt.Errorf("header chain: shard %v height %d: equivocation detected, existing root %s != new root %s",
+				chain.ShardID, hdr.Height, prev.MsgRoot.ToHexString(), hdr.MsgRoot.ToHexString())
+		}
+		return nil
+	}
+	if chain.store != nil {
+		if err := chain.store.Put(hdr); err != nil {
+			return fmt.Errorf("header chain: shard %v height %d: persist header: %s", chain.ShardID, hdr.Height, err)
+		}
+	}
+	chain.headers[hdr.Height] = hdr
+	return nil
+}
+
+// verifySignaturesLocked requires a 2f+1-of-n quorum of hdr.BookkeeperSigs to
+// verify against hdr.signingDigest() under keys drawn from the source
+// shard's authorized bookkeeper set (never hdr's own self-declared keys).
+func (chain *HeaderChain) verifySignaturesLocked(hdr *ShardHeaderSummary) error {
+	if len(hdr.Bookkeepers) == 0 || len(hdr.BookkeeperSigs) == 0 {
+		return fmt.Errorf("header chain: shard %v height %d: missing consensus signatures", chain.ShardID, hdr.Height)
+	}
+	if len(hdr.Bookkeepers) != len(hdr.BookkeeperSigs) {
+		return fmt.Errorf("header chain: shard %v height %d: %d signers but %d signatures", chain.ShardID, hdr.Height, len(hdr.Bookkeepers), len(hdr.BookkeeperSigs))
+	}
+	if chain.bookkeepers == nil {
+		return fmt.Errorf("header chain: shard %v: no authorized bookkeeper set configured, refusing header at height %d", chain.ShardID, hdr.Height)
+	}
+	authorized, err := chain.bookkeepers(hdr.ShardID, hdr.Height)
+	if err != nil {
+		return fmt.Errorf("header chain: shard %v height %d: load bookkeeper set: %s", chain.ShardID, hdr.Height, err)
+	}
+	if len(authorized) == 0 {
+		return fmt.Errorf("header chain: shard %v height %d: empty bookkeeper set", chain.ShardID, hdr.Height)
+	}
+	authorizedSet := make(map[string]bool, len(authorized))
+	for _, pk := range authorized {
+		authorizedSet[string(keypair.SerializePublicKey(pk))] = true
+	}
+
+	digest := hdr.signingDigest()
+	valid := 0
+	seen := make(map[string]bool, len(hdr.Bookkeepers))
+	for i, pk := range hdr.Bookkeepers {
+		key := string(keypair.SerializePublicKey(pk))
+		if !authorizedSet[key] {
+			return fmt.Errorf("header chain: shard %v height %d: signer %d is not an authorized bookkeeper", chain.ShardID, hdr.Height, i)
+		}
+		if seen[key] {
+			return fmt.Errorf("header chain: shard %v height %d: signer %d is duplicated", chain.ShardID, hdr.Height, i)
+		}
+		seen[key] = true
+		if err := signature.Verify(pk, digest, hdr.BookkeeperSigs[i]); err != nil {
+			return fmt.Errorf("header chain: shard %v height %d: invalid signature from signer %d: %s", chain.ShardID, hdr.Height, i, err)
+		}
+		valid++
+	}
+	quorum := len(authorized) - (len(authorized)-1)/3 // 2f+1 of n = 3f+1
+	if valid < quorum {
+		return fmt.Errorf("header chain: shard %v height %d: only %d/%d required bookkeeper signatures verified", chain.ShardID, hdr.Height, valid, quorum)
+	}
+	return nil
+}
+
+func (chain *HeaderChain) HeaderByHeight(height uint32) (*ShardHeaderSummary, bool) {
+	chain.lock.RLock()
+	defer chain.lock.RUnlock()
+	hdr, present := chain.headers[height]
+	return hdr, present
+}
+
+// HeaderChainDataDir, when non-empty, roots a NewFileHeaderStore for every
+// HeaderChain getOrCreateHeaderChain creates from here on, so accepted
+// headers survive a restart instead of resetting to empty. Wire it (e.g. to
+// the same dataDir passed to keeper.InitKeeperManager) before the first
+// header for a shard arrives; leaving it empty keeps headers in-process only.
+var HeaderChainDataDir string
+
+// getOrCreateHeaderChain returns the HeaderChain for fromShardID, creating it
+// if this is the first header/proof seen for that source shard.
+func (pool *CrossShardPool) getOrCreateHeaderChain(fromShardID common.ShardID) *HeaderChain {
+	chain, present := pool.HeaderChains[fromShardID.ToUint64()]
+	if present {
+		return chain
+	}
+	if HeaderChainDataDir == "" {
+		chain = NewHeaderChain(fromShardID, ShardBookkeeperProvider)
+	} else {
+		store, err := NewFileHeaderStore(filepath.Join(HeaderChainDataDir, fmt.Sprintf("shard-%d", fromShardID.ToUint64())))
+		if err != nil {
+			log.Errorf("getOrCreateHeaderChain shard %v: %s, falling back to in-process only", fromShardID, err)
+			chain = NewHeaderChain(fromShardID, ShardBookkeeperProvider)
+		} else {
+			chain = NewPersistentHeaderChain(fromShardID, ShardBookkeeperProvider, store)
+		}
+	}
+	pool.HeaderChains[fromShardID.ToUint64()] = chain
+	return chain
+}
+
+// AddShardHeader records a source-shard header summary, validating its
+// bookkeeper signatures and parent linkage against the locally maintained
+// HeaderChain. The chain survives a restart when HeaderChainDataDir is
+// configured (see getOrCreateHeaderChain); otherwise it is rebuilt by
+// re-receiving headers from relays, same as before.
+func AddShardHeader(lgr *ledger.Ledger, hdr *ShardHeaderSummary) error {
+	pool := crossShardPool
+	pool.lock.Lock()
+	defer pool.lock.Unlock()
+
+	chain := pool.getOrCreateHeaderChain(hdr.ShardID)
+	if err := chain.AddHeader(hdr); err != nil {
+		return err
+	}
+	return nil
+}
+
+// VerifyCrossShardProof checks that proof.LeafHash is the msg's own
+// PreCrossShardMsgHash and that it Merkle-verifies against header, per
+// VerifyMerkleInclusion.
+func VerifyCrossShardProof(header *ShardHeaderSummary, proof *CrossShardMsgProof, msg *types.CrossShardMsg) error {
+	if msg == nil {
+		return fmt.Errorf("verify cross shard proof: nil message")
+	}
+	if proof == nil {
+		return fmt.Errorf("verify cross shard proof: nil proof")
+	}
+	leaf := msg.CrossShardMsgInfo.PreCrossShardMsgHash
+	if leaf != proof.LeafHash {
+		return fmt.Errorf("verify cross shard proof: msg pre-hash %s != proof leaf %s", leaf.ToHexString(), proof.LeafHash.ToHexString())
+	}
+	return VerifyMerkleInclusion(header, proof)
+}
+
+// VerifyMerkleInclusion checks that proof.Root matches the CrossShardMsgRoot
+// committed by header at proof.Height, and that proof.LeafHash recomputes to
+// proof.Root via the sibling path. Unlike VerifyCrossShardProof, it takes no
+// opinion on what proof.LeafHash is the hash of, so callers proving inclusion
+// of something other than a CrossShardMsg (e.g. a single shardmgmt event) can
+// reuse the same Merkle-path check.
+func VerifyMerkleInclusion(header *ShardHeaderSummary, proof *CrossShardMsgProof) error {
+	if header == nil || proof == nil {
+		return fmt.Errorf("verify merkle inclusion: nil argument")
+	}
+	if header.Height != proof.Height {
+		return fmt.Errorf("verify merkle inclusion: header height %d != proof height %d", header.Height, proof.Height)
+	}
+	if header.MsgRoot != proof.Root {
+		return fmt.Errorf("verify merkle inclusion: header msg root %s != proof root %s", header.MsgRoot.ToHexString(), proof.Root.ToHexString())
+	}
+
+	hash := proof.LeafHash
+	bits := proof.PathBits
+	for _, sibling := range proof.SiblingPath {
+		buf := new(bytes.Buffer)
+		if bits&1 == 0 {
+			buf.Write(hash[:])
+			buf.Write(sibling[:])
+		} else {
+			buf.Write(sibling[:])
+			buf.Write(hash[:])
+		}
+		hash = common.Uint256(sha256.Sum256(buf.Bytes()))
+		bits >>= 1
+	}
+	if hash != proof.Root {
+		return fmt.Errorf("verify merkle inclusion: computed root %s != expected root %s", hash.ToHexString(), proof.Root.ToHexString())
+	}
+	return nil
+}
+
+// AddCrossShardInfoWithProof is the untrusted-relay counterpart of
+// AddCrossShardInfo: it requires crossShardMsg to carry a CrossShardMsgProof
+// against a header already accepted into the source shard's HeaderChain, and
+// quarantines the message instead of adding it to the pool when the proof
+// fails verification.
+func AddCrossShardInfoWithProof(lgr *ledger.Ledger, crossShardMsg *types.CrossShardMsg, proof *CrossShardMsgProof) error {
+	pool := crossShardPool
+	fromShardID := crossShardMsg.CrossShardMsgInfo.FromShardID
+
+	pool.lock.RLock()
+	chain, present := pool.HeaderChains[fromShardID.ToUint64()]
+	pool.lock.RUnlock()
+	if !present {
+		return fmt.Errorf("AddCrossShardInfoWithProof shardID:%v: no header chain", fromShardID)
+	}
+	header, present := chain.HeaderByHeight(proof.Height)
+	if !present {
+		return fmt.Errorf("AddCrossShardInfoWithProof shardID:%v,height:%d: header not found", fromShardID, proof.Height)
+	}
+
+	if err := VerifyCrossShardProof(header, proof, crossShardMsg); err != nil {
+		pool.lock.Lock()
+		bucket, present := pool.Quarantine[fromShardID.ToUint64()]
+		if !present {
+			bucket = newQuarantineBucket(pool.MaxBlockCap)
+			pool.Quarantine[fromShardID.ToUint64()] = bucket
+		}
+		bucket.add(crossShardMsg)
+		pool.lock.Unlock()
+		log.Errorf("AddCrossShardInfoWithProof quarantined msg from shard %v: %s", fromShardID, err)
+		return fmt.Errorf("AddCrossShardInfoWithProof: proof verification failed: %s", err)
+	}
+
+	return AddCrossShardInfo(lgr, crossShardMsg)
+}
+
+// quarantineBucket holds, per source shard, messages whose inclusion proof
+// failed verification, bounded the same way ShardTxQueue bounds its confirmed
+// tier: oldest-first eviction once the bucket exceeds its cap, so a flood of
+// bad proofs cannot grow pool.Quarantine without bound.
+type quarantineBucket struct {
+	maxCap  uint32
+	entries map[common.Uint256]*types.CrossShardMsg
+	order   []common.Uint256 // oldest-first
+	evicted uint64
+}
+
+func newQuarantineBucket(maxCap uint32) *quarantineBucket {
+	return &quarantineBucket{
+		maxCap:  maxCap,
+		entries: make(map[common.Uint256]*types.CrossShardMsg),
+	}
+}
+
+func (b *quarantineBucket) add(msg *types.CrossShardMsg) {
+	hash := msg.CrossShardMsgInfo.PreCrossShardMsgHash
+	if _, present := b.entries[hash]; !present {
+		b.order = append(b.order, hash)
+	}
+	b.entries[hash] = msg
+	if b.maxCap == 0 {
+		return
+	}
+	for uint32(len(b.entries)) > b.maxCap && len(b.order) > 0 {
+		oldest := b.order[0]
+		b.order = b.order[1:]
+		if _, present := b.entries[oldest]; present {
+			delete(b.entries, oldest)
+			b.evicted++
+		}
+	}
+}
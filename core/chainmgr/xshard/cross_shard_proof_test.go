@@ -0,0 +1,189 @@
+/*
+ * Copyright (C) 2019 The ontology Authors
+ * This file is part of The ontology library.
+ *
+ * The ontology is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The ontology is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public License
+ * along with The ontology.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package xshard
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/ontio/ontology-crypto/keypair"
+	"github.com/ontio/ontology/common"
+)
+
+func testShardID(t testing.TB, id uint64) common.ShardID {
+	shardID, err := common.NewShardID(id)
+	if err != nil {
+		t.Fatalf("common.NewShardID(%d): %s", id, err)
+	}
+	return shardID
+}
+
+// buildTwoLeafProof builds a minimal 2-leaf Merkle tree (leaf, sibling) and
+// returns the proof for leaf plus the resulting root, matching the
+// left(0)/right(1) bit convention VerifyMerkleInclusion expects.
+func buildTwoLeafProof(leaf, sibling common.Uint256, height uint32) *CrossShardMsgProof {
+	buf := new(bytes.Buffer)
+	buf.Write(leaf[:])
+	buf.Write(sibling[:])
+	root := common.Uint256(sha256.Sum256(buf.Bytes()))
+	return &CrossShardMsgProof{
+		LeafHash:    leaf,
+		SiblingPath: []common.Uint256{sibling},
+		PathBits:    0, // leaf is the left child
+		Height:      height,
+		Root:        root,
+	}
+}
+
+func TestVerifyMerkleInclusion(t *testing.T) {
+	leaf := common.Uint256(sha256.Sum256([]byte("leaf")))
+	sibling := common.Uint256(sha256.Sum256([]byte("sibling")))
+	proof := buildTwoLeafProof(leaf, sibling, 10)
+	header := &ShardHeaderSummary{Height: 10, MsgRoot: proof.Root}
+
+	if err := VerifyMerkleInclusion(header, proof); err != nil {
+		t.Fatalf("VerifyMerkleInclusion: unexpected error: %s", err)
+	}
+}
+
+func TestVerifyMerkleInclusionRejectsWrongRoot(t *testing.T) {
+	leaf := common.Uint256(sha256.Sum256([]byte("leaf")))
+	sibling := common.Uint256(sha256.Sum256([]byte("sibling")))
+	proof := buildTwoLeafProof(leaf, sibling, 10)
+	header := &ShardHeaderSummary{Height: 10, MsgRoot: common.Uint256(sha256.Sum256([]byte("wrong")))}
+
+	if err := VerifyMerkleInclusion(header, proof); err == nil {
+		t.Fatalf("VerifyMerkleInclusion: expected error for mismatched root, got nil")
+	}
+}
+
+func TestVerifyMerkleInclusionRejectsTamperedSibling(t *testing.T) {
+	leaf := common.Uint256(sha256.Sum256([]byte("leaf")))
+	sibling := common.Uint256(sha256.Sum256([]byte("sibling")))
+	proof := buildTwoLeafProof(leaf, sibling, 10)
+	header := &ShardHeaderSummary{Height: 10, MsgRoot: proof.Root}
+
+	proof.SiblingPath[0] = common.Uint256(sha256.Sum256([]byte("tampered")))
+	if err := VerifyMerkleInclusion(header, proof); err == nil {
+		t.Fatalf("VerifyMerkleInclusion: expected error for tampered sibling, got nil")
+	}
+}
+
+func TestHeaderChainAddHeaderRejectsMissingSignatures(t *testing.T) {
+	shardID := testShardID(t, 1)
+	chain := NewHeaderChain(shardID, nil)
+	hdr := &ShardHeaderSummary{
+		ShardID: shardID,
+		Height:  0,
+		MsgRoot: common.Uint256(sha256.Sum256([]byte("root"))),
+	}
+	if err := chain.AddHeader(hdr); err == nil {
+		t.Fatalf("AddHeader: expected error for a header with no signatures, got nil")
+	}
+}
+
+func TestHeaderChainAddHeaderRejectsNoBookkeeperProvider(t *testing.T) {
+	shardID := testShardID(t, 1)
+	chain := NewHeaderChain(shardID, nil)
+	hdr := &ShardHeaderSummary{
+		ShardID:        shardID,
+		Height:         0,
+		MsgRoot:        common.Uint256(sha256.Sum256([]byte("root"))),
+		Bookkeepers:    []keypair.PublicKey{nil},
+		BookkeeperSigs: [][]byte{[]byte("sig")},
+	}
+	if err := chain.AddHeader(hdr); err == nil {
+		t.Fatalf("AddHeader: expected error with no bookkeeper provider configured, got nil")
+	}
+}
+
+func TestFileHeaderStorePutAndListRoundTrip(t *testing.T) {
+	dir, err := ioutil.TempDir("", "header-store-test")
+	if err != nil {
+		t.Fatalf("TempDir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	store, err := NewFileHeaderStore(dir)
+	if err != nil {
+		t.Fatalf("NewFileHeaderStore: %s", err)
+	}
+	shardID := testShardID(t, 1)
+	hdr := &ShardHeaderSummary{
+		ShardID:        shardID,
+		Height:         3,
+		ParentHash:     common.Uint256(sha256.Sum256([]byte("parent"))),
+		MsgRoot:        common.Uint256(sha256.Sum256([]byte("root"))),
+		Bookkeepers:    []keypair.PublicKey{},
+		BookkeeperSigs: [][]byte{},
+	}
+	if err := store.Put(hdr); err != nil {
+		t.Fatalf("Put: %s", err)
+	}
+
+	// A fresh store instance pointed at the same directory simulates a
+	// restart: List must recover exactly what was persisted.
+	reopened, err := NewFileHeaderStore(dir)
+	if err != nil {
+		t.Fatalf("NewFileHeaderStore (reopen): %s", err)
+	}
+	loaded := reopened.List(shardID)
+	if len(loaded) != 1 {
+		t.Fatalf("List = %d headers, want 1", len(loaded))
+	}
+	if loaded[0].Height != hdr.Height || loaded[0].MsgRoot != hdr.MsgRoot || loaded[0].ParentHash != hdr.ParentHash {
+		t.Fatalf("List[0] = %+v, want %+v", loaded[0], hdr)
+	}
+}
+
+func TestNewPersistentHeaderChainLoadsExistingHeaders(t *testing.T) {
+	dir, err := ioutil.TempDir("", "header-chain-test")
+	if err != nil {
+		t.Fatalf("TempDir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	shardID := testShardID(t, 1)
+	store, err := NewFileHeaderStore(dir)
+	if err != nil {
+		t.Fatalf("NewFileHeaderStore: %s", err)
+	}
+	hdr := &ShardHeaderSummary{
+		ShardID: shardID,
+		Height:  0,
+		MsgRoot: common.Uint256(sha256.Sum256([]byte("root"))),
+	}
+	if err := store.Put(hdr); err != nil {
+		t.Fatalf("Put: %s", err)
+	}
+
+	// Simulates a restart: the chain is reconstructed from whatever the
+	// store already holds, rather than starting empty.
+	chain := NewPersistentHeaderChain(shardID, nil, store)
+	got, present := chain.HeaderByHeight(0)
+	if !present {
+		t.Fatalf("HeaderByHeight(0): expected the persisted header to be loaded")
+	}
+	if got.MsgRoot != hdr.MsgRoot {
+		t.Fatalf("HeaderByHeight(0).MsgRoot = %s, want %s", got.MsgRoot.ToHexString(), hdr.MsgRoot.ToHexString())
+	}
+}
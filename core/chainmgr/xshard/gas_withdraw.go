@@ -0,0 +1,295 @@
+/*
+ * Copyright (C) 2019 The ontology Authors
+ * This file is part of The ontology library.
+ *
+ * The ontology is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The ontology is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public License
+ * along with The ontology.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package xshard
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/ontio/ontology/common"
+	"github.com/ontio/ontology/common/log"
+	"github.com/ontio/ontology/core/ledger"
+	"github.com/ontio/ontology/core/types"
+	"github.com/ontio/ontology/smartcontract/service/native/shardmgmt/states"
+)
+
+// DecodeCrossShardMsgProof decodes the LockProof bytes carried by a
+// WithdrawGasClaimEvent back into the CrossShardMsgProof the claimant must
+// have obtained from the source shard, so NewWithdrawGasClaimFromEvent can
+// verify it instead of trusting an opaque blob.
+func DecodeCrossShardMsgProof(lockProof []byte) (*CrossShardMsgProof, error) {
+	proof := &CrossShardMsgProof{}
+	if err := proof.Deserialize(bytes.NewReader(lockProof)); err != nil {
+		return nil, fmt.Errorf("DecodeCrossShardMsgProof: %s", err)
+	}
+	return proof, nil
+}
+
+// toCommonShardID bridges shardmgmt's types.ShardID (used by native contract
+// events) into the common.ShardID this package otherwise deals in
+// exclusively (CrossShardMsgInfo, HeaderChain, CrossShardPool).
+func toCommonShardID(id types.ShardID) (common.ShardID, error) {
+	shardID, err := common.NewShardID(id.ToUint64())
+	if err != nil {
+		return common.ShardID{}, fmt.Errorf("toCommonShardID: %s", err)
+	}
+	return shardID, nil
+}
+
+// WithdrawGasClaim is the information a WithdrawGasClaimEvent needs verified
+// before it may be accepted on the parent shard: the WithdrawGasPreparedEvent
+// it claims to settle, bound field-for-field to the claim, plus a Merkle
+// proof that the prepared event was actually committed into the source
+// shard's CrossShardMsgRoot at MsgHeight.
+type WithdrawGasClaim struct {
+	SourceShardID common.ShardID
+	Nonce         uint64
+	Expiry        uint64
+	MsgHeight     uint32
+	Proof         *CrossShardMsgProof
+	Prepared      *shardstates.WithdrawGasPreparedEvent
+}
+
+// NewWithdrawGasClaim binds a WithdrawGasClaimEvent to the
+// WithdrawGasPreparedEvent its LockProof was issued against, rejecting the
+// pairing unless every field the claim asserts (receiver, amount, expiry,
+// msg height, nonce) matches the prepared event exactly. This is what
+// prevents a caller from pairing an attacker-chosen nonce/amount/receiver
+// with an unrelated, otherwise-valid proof.
+func NewWithdrawGasClaim(claimEvt *shardstates.WithdrawGasClaimEvent, prepared *shardstates.WithdrawGasPreparedEvent, proof *CrossShardMsgProof) (*WithdrawGasClaim, error) {
+	if prepared.SourceShardID != claimEvt.SourceShardID {
+		return nil, fmt.Errorf("NewWithdrawGasClaim: claim source shard %v != prepared source shard %v", claimEvt.SourceShardID, prepared.SourceShardID)
+	}
+	if prepared.Nonce != claimEvt.Nonce {
+		return nil, fmt.Errorf("NewWithdrawGasClaim: claim nonce %d != prepared nonce %d", claimEvt.Nonce, prepared.Nonce)
+	}
+	if prepared.Receiver != claimEvt.Receiver {
+		return nil, fmt.Errorf("NewWithdrawGasClaim: claim receiver %s != prepared receiver %s", claimEvt.Receiver.ToBase58(), prepared.Receiver.ToBase58())
+	}
+	if prepared.Amount != claimEvt.Amount {
+		return nil, fmt.Errorf("NewWithdrawGasClaim: claim amount %d != prepared amount %d", claimEvt.Amount, prepared.Amount)
+	}
+	if prepared.Expiry != claimEvt.Expiry {
+		return nil, fmt.Errorf("NewWithdrawGasClaim: claim expiry %d != prepared expiry %d", claimEvt.Expiry, prepared.Expiry)
+	}
+	if prepared.MsgHeight != claimEvt.MsgHeight {
+		return nil, fmt.Errorf("NewWithdrawGasClaim: claim msg height %d != prepared msg height %d", claimEvt.MsgHeight, prepared.MsgHeight)
+	}
+
+	sourceShardID, err := toCommonShardID(claimEvt.SourceShardID)
+	if err != nil {
+		return nil, fmt.Errorf("NewWithdrawGasClaim: %s", err)
+	}
+
+	return &WithdrawGasClaim{
+		SourceShardID: sourceShardID,
+		Nonce:         claimEvt.Nonce,
+		Expiry:        claimEvt.Expiry,
+		MsgHeight:     claimEvt.MsgHeight,
+		Proof:         proof,
+		Prepared:      prepared,
+	}, nil
+}
+
+// NewWithdrawGasClaimFromEvent is the end-to-end entry point a parent shard
+// uses on receiving a WithdrawGasClaimEvent: it decodes the event's LockProof
+// into a CrossShardMsgProof, then binds it to prepared via
+// NewWithdrawGasClaim. Callers should pass the result straight to
+// VerifyWithdrawGasClaim.
+func NewWithdrawGasClaimFromEvent(claimEvt *shardstates.WithdrawGasClaimEvent, prepared *shardstates.WithdrawGasPreparedEvent) (*WithdrawGasClaim, error) {
+	proof, err := DecodeCrossShardMsgProof(claimEvt.LockProof)
+	if err != nil {
+		return nil, fmt.Errorf("NewWithdrawGasClaimFromEvent: %s", err)
+	}
+	return NewWithdrawGasClaim(claimEvt, prepared, proof)
+}
+
+// SpentNonceStore persists claimed (shardID, nonce) pairs so a restart does
+// not rely solely on the source shard's CrossShardMsgRoot history (replayed
+// via AddShardHeader/AddCrossShardInfo) to reject a stale claim replay.
+// fileSpentNonceStore is the only implementation: one empty marker file per
+// claimed nonce, written the same atomic way as keeper's Store and
+// HeaderStore.
+type SpentNonceStore interface {
+	MarkSpent(shardID common.ShardID, nonce uint64) error
+	IsSpent(shardID common.ShardID, nonce uint64) bool
+}
+
+type fileSpentNonceStore struct {
+	lock    sync.Mutex
+	baseDir string
+}
+
+// NewFileSpentNonceStore opens (creating if necessary) a spent-nonce store
+// rooted at baseDir.
+func NewFileSpentNonceStore(baseDir string) (SpentNonceStore, error) {
+	if err := os.MkdirAll(baseDir, 0750); err != nil {
+		return nil, fmt.Errorf("NewFileSpentNonceStore: create %s: %s", baseDir, err)
+	}
+	return &fileSpentNonceStore{baseDir: baseDir}, nil
+}
+
+func spentNonceFileName(shardID common.ShardID, nonce uint64) string {
+	return fmt.Sprintf("%d-%d.spent", shardID.ToUint64(), nonce)
+}
+
+func (s *fileSpentNonceStore) MarkSpent(shardID common.ShardID, nonce uint64) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	path := filepath.Join(s.baseDir, spentNonceFileName(shardID, nonce))
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, []byte{}, 0640); err != nil {
+		return fmt.Errorf("spent nonce file store: write %s: %s", tmp, err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("spent nonce file store: rename %s: %s", tmp, err)
+	}
+	return nil
+}
+
+func (s *fileSpentNonceStore) IsSpent(shardID common.ShardID, nonce uint64) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	_, err := os.Stat(filepath.Join(s.baseDir, spentNonceFileName(shardID, nonce)))
+	return err == nil
+}
+
+// SpentNonceDataDir, when non-empty, backs the spent-nonce guard below with a
+// NewFileSpentNonceStore rooted there, so marking a nonce spent survives a
+// restart. Wire it up before the first withdraw claim is verified; leaving it
+// empty keeps the previous in-process-only behavior.
+var SpentNonceDataDir string
+
+// spentNonces is an in-process cache of claimed (shardID, nonce) pairs,
+// checked first for speed; spentNonceStore (when SpentNonceDataDir is
+// configured) is the durable source of truth consulted on a cache miss and
+// written through to on every new claim.
+var (
+	spentNonceLock             sync.Mutex
+	spentNonces                = map[uint64]map[uint64]bool{}
+	spentNonceStore            SpentNonceStore
+	spentNonceStoreInitialized bool
+)
+
+// spentNonceStoreLocked lazily resolves spentNonceStore from
+// SpentNonceDataDir the first time it is needed, and caches the result (even
+// nil, when unconfigured or misconfigured) for the rest of the process.
+// Callers must already hold spentNonceLock.
+func spentNonceStoreLocked() SpentNonceStore {
+	if spentNonceStoreInitialized {
+		return spentNonceStore
+	}
+	spentNonceStoreInitialized = true
+	if SpentNonceDataDir == "" {
+		return nil
+	}
+	store, err := NewFileSpentNonceStore(SpentNonceDataDir)
+	if err != nil {
+		log.Errorf("spent nonce store: %s, falling back to in-process only", err)
+		return nil
+	}
+	spentNonceStore = store
+	return spentNonceStore
+}
+
+// IsWithdrawNonceSpent reports whether (shardID, nonce) has already been
+// claimed, checking the in-process cache before falling back to the durable
+// store.
+func IsWithdrawNonceSpent(lgr *ledger.Ledger, shardID common.ShardID, nonce uint64) (bool, error) {
+	spentNonceLock.Lock()
+	defer spentNonceLock.Unlock()
+	if spentNonces[shardID.ToUint64()][nonce] {
+		return true, nil
+	}
+	if store := spentNonceStoreLocked(); store != nil && store.IsSpent(shardID, nonce) {
+		return true, nil
+	}
+	return false, nil
+}
+
+// markWithdrawNonceSpent records that (shardID, nonce) has been claimed, so a
+// later replay of the same WithdrawGasClaimEvent is rejected both for the
+// lifetime of this process and, when SpentNonceDataDir is configured, across
+// a restart.
+func markWithdrawNonceSpent(lgr *ledger.Ledger, shardID common.ShardID, nonce uint64) error {
+	spentNonceLock.Lock()
+	defer spentNonceLock.Unlock()
+	if store := spentNonceStoreLocked(); store != nil {
+		if err := store.MarkSpent(shardID, nonce); err != nil {
+			return fmt.Errorf("markWithdrawNonceSpent shardID:%v,nonce:%d: %s", shardID, nonce, err)
+		}
+	}
+	if spentNonces[shardID.ToUint64()] == nil {
+		spentNonces[shardID.ToUint64()] = make(map[uint64]bool)
+	}
+	spentNonces[shardID.ToUint64()][nonce] = true
+	return nil
+}
+
+// VerifyWithdrawGasClaim checks claim's expiry, rejects it if (SourceShardID,
+// Nonce) was already spent, verifies that claim.Prepared is exactly the
+// message committed at claim.Proof's leaf (not merely some message at that
+// height), verifies claim.Proof against the source shard's HeaderChain, and
+// on success marks the nonce spent so it cannot be replayed.
+func VerifyWithdrawGasClaim(lgr *ledger.Ledger, currentHeight uint64, claim *WithdrawGasClaim) error {
+	if claim.Expiry != 0 && currentHeight > claim.Expiry {
+		return fmt.Errorf("VerifyWithdrawGasClaim shardID:%v,nonce:%d: claim expired at height %d, current height %d",
+			claim.SourceShardID, claim.Nonce, claim.Expiry, currentHeight)
+	}
+
+	spent, err := IsWithdrawNonceSpent(lgr, claim.SourceShardID, claim.Nonce)
+	if err != nil {
+		return err
+	}
+	if spent {
+		return fmt.Errorf("VerifyWithdrawGasClaim shardID:%v,nonce:%d: already claimed", claim.SourceShardID, claim.Nonce)
+	}
+
+	encoded, err := shardstates.EncodeShardEvent(claim.Prepared)
+	if err != nil {
+		return fmt.Errorf("VerifyWithdrawGasClaim shardID:%v,nonce:%d: encode prepared event: %s", claim.SourceShardID, claim.Nonce, err)
+	}
+	leafHash := common.Uint256(sha256.Sum256(encoded))
+	if leafHash != claim.Proof.LeafHash {
+		return fmt.Errorf("VerifyWithdrawGasClaim shardID:%v,nonce:%d: proof leaf %s does not match the prepared event's committed hash %s",
+			claim.SourceShardID, claim.Nonce, claim.Proof.LeafHash.ToHexString(), leafHash.ToHexString())
+	}
+
+	pool := crossShardPool
+	pool.lock.RLock()
+	chain, present := pool.HeaderChains[claim.SourceShardID.ToUint64()]
+	pool.lock.RUnlock()
+	if !present {
+		return fmt.Errorf("VerifyWithdrawGasClaim shardID:%v: no header chain", claim.SourceShardID)
+	}
+	header, present := chain.HeaderByHeight(claim.MsgHeight)
+	if !present {
+		return fmt.Errorf("VerifyWithdrawGasClaim shardID:%v,height:%d: header not found", claim.SourceShardID, claim.MsgHeight)
+	}
+	if err := VerifyMerkleInclusion(header, claim.Proof); err != nil {
+		return fmt.Errorf("VerifyWithdrawGasClaim shardID:%v,nonce:%d: %s", claim.SourceShardID, claim.Nonce, err)
+	}
+
+	return markWithdrawNonceSpent(lgr, claim.SourceShardID, claim.Nonce)
+}
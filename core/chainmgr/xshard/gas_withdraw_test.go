@@ -0,0 +1,175 @@
+/*
+ * Copyright (C) 2019 The ontology Authors
+ * This file is part of The ontology library.
+ *
+ * The ontology is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The ontology is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public License
+ * along with The ontology.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package xshard
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/ontio/ontology/common"
+	"github.com/ontio/ontology/core/types"
+	"github.com/ontio/ontology/smartcontract/service/native/shardmgmt/states"
+)
+
+func testSourceTargetShardID(t testing.TB) shardstates.ImplSourceTargetShardID {
+	source, err := types.NewShardID(1)
+	if err != nil {
+		t.Fatalf("types.NewShardID(1): %s", err)
+	}
+	target, err := types.NewShardID(0)
+	if err != nil {
+		t.Fatalf("types.NewShardID(0): %s", err)
+	}
+	return shardstates.ImplSourceTargetShardID{SourceShardID: source, ShardID: target}
+}
+
+func testProof(t testing.TB, leaf common.Uint256) *CrossShardMsgProof {
+	sibling := testHash(t, "gas-withdraw-sibling")
+	return buildTwoLeafProof(leaf, sibling, 5)
+}
+
+func TestCrossShardMsgProofSerializeRoundTrip(t *testing.T) {
+	proof := testProof(t, testHash(t, "leaf"))
+
+	buf := new(bytes.Buffer)
+	if err := proof.Serialize(buf); err != nil {
+		t.Fatalf("Serialize: %s", err)
+	}
+	decoded := &CrossShardMsgProof{}
+	if err := decoded.Deserialize(buf); err != nil {
+		t.Fatalf("Deserialize: %s", err)
+	}
+	if decoded.LeafHash != proof.LeafHash || decoded.Root != proof.Root || decoded.Height != proof.Height || decoded.PathBits != proof.PathBits {
+		t.Fatalf("Deserialize = %+v, want %+v", decoded, proof)
+	}
+	if len(decoded.SiblingPath) != len(proof.SiblingPath) || decoded.SiblingPath[0] != proof.SiblingPath[0] {
+		t.Fatalf("Deserialize.SiblingPath = %v, want %v", decoded.SiblingPath, proof.SiblingPath)
+	}
+}
+
+func TestNewWithdrawGasClaimFromEventWiresLockProof(t *testing.T) {
+	src := testSourceTargetShardID(t)
+	prepared := &shardstates.WithdrawGasPreparedEvent{
+		ImplSourceTargetShardID: src,
+		Receiver:                common.ADDRESS_EMPTY,
+		Amount:                  100,
+		Nonce:                   7,
+		Expiry:                  1000,
+		MsgHeight:               5,
+	}
+	leaf, err := shardstates.EncodeShardEvent(prepared)
+	if err != nil {
+		t.Fatalf("EncodeShardEvent: %s", err)
+	}
+	proof := testProof(t, common.Uint256(sha256.Sum256(leaf)))
+	buf := new(bytes.Buffer)
+	if err := proof.Serialize(buf); err != nil {
+		t.Fatalf("Serialize proof: %s", err)
+	}
+
+	claimEvt := &shardstates.WithdrawGasClaimEvent{
+		ImplSourceTargetShardID: src,
+		Receiver:                common.ADDRESS_EMPTY,
+		Amount:                  100,
+		Nonce:                   7,
+		Expiry:                  1000,
+		MsgHeight:               5,
+		LockProof:               buf.Bytes(),
+	}
+
+	claim, err := NewWithdrawGasClaimFromEvent(claimEvt, prepared)
+	if err != nil {
+		t.Fatalf("NewWithdrawGasClaimFromEvent: %s", err)
+	}
+	if claim.Nonce != 7 || claim.Proof.Root != proof.Root {
+		t.Fatalf("claim = %+v, want nonce 7 and proof root %s", claim, proof.Root.ToHexString())
+	}
+}
+
+func TestNewWithdrawGasClaimFromEventRejectsMismatchedNonce(t *testing.T) {
+	src := testSourceTargetShardID(t)
+	prepared := &shardstates.WithdrawGasPreparedEvent{
+		ImplSourceTargetShardID: src,
+		Receiver:                common.ADDRESS_EMPTY,
+		Amount:                  100,
+		Nonce:                   7,
+		Expiry:                  1000,
+		MsgHeight:               5,
+	}
+	proof := testProof(t, testHash(t, "unrelated-leaf"))
+	buf := new(bytes.Buffer)
+	if err := proof.Serialize(buf); err != nil {
+		t.Fatalf("Serialize proof: %s", err)
+	}
+
+	// A forged claim reusing a valid historical proof but choosing its own
+	// nonce must be rejected by field binding, not silently accepted.
+	claimEvt := &shardstates.WithdrawGasClaimEvent{
+		ImplSourceTargetShardID: src,
+		Receiver:                common.ADDRESS_EMPTY,
+		Amount:                  100,
+		Nonce:                   99,
+		Expiry:                  1000,
+		MsgHeight:               5,
+		LockProof:               buf.Bytes(),
+	}
+
+	if _, err := NewWithdrawGasClaimFromEvent(claimEvt, prepared); err == nil {
+		t.Fatalf("NewWithdrawGasClaimFromEvent: expected error for mismatched nonce, got nil")
+	}
+}
+
+func TestFileSpentNonceStoreMarkAndIsSpentSurvivesReopen(t *testing.T) {
+	dir, err := ioutil.TempDir("", "spent-nonce-store-test")
+	if err != nil {
+		t.Fatalf("TempDir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	shardID := testShardID(t, 1)
+	store, err := NewFileSpentNonceStore(dir)
+	if err != nil {
+		t.Fatalf("NewFileSpentNonceStore: %s", err)
+	}
+	if store.IsSpent(shardID, 7) {
+		t.Fatalf("IsSpent(7): expected false before MarkSpent")
+	}
+	if err := store.MarkSpent(shardID, 7); err != nil {
+		t.Fatalf("MarkSpent: %s", err)
+	}
+	if !store.IsSpent(shardID, 7) {
+		t.Fatalf("IsSpent(7): expected true after MarkSpent")
+	}
+	if store.IsSpent(shardID, 8) {
+		t.Fatalf("IsSpent(8): expected false, a different nonce was never marked")
+	}
+
+	// A fresh store instance pointed at the same directory simulates a
+	// restart: the mark must still be visible.
+	reopened, err := NewFileSpentNonceStore(dir)
+	if err != nil {
+		t.Fatalf("NewFileSpentNonceStore (reopen): %s", err)
+	}
+	if !reopened.IsSpent(shardID, 7) {
+		t.Fatalf("IsSpent(7) after reopen: expected true, mark should have survived")
+	}
+}
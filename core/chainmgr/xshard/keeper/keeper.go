@@ -0,0 +1,392 @@
+/*
+ * Copyright (C) 2019 The ontology Authors
+ * This file is part of The ontology library.
+ *
+ * The ontology is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The ontology is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public License
+ * along with The ontology.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package keeper
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/ontio/ontology/account"
+	"github.com/ontio/ontology/common"
+	"github.com/ontio/ontology/common/config"
+	"github.com/ontio/ontology/common/log"
+	crossshard "github.com/ontio/ontology/core/chainmgr/message"
+	"github.com/ontio/ontology/core/chainmgr/xshard"
+	"github.com/ontio/ontology/core/ledger"
+	"github.com/ontio/ontology/core/types"
+)
+
+const (
+	defaultPollInterval = 2 * time.Second
+	minBackoff          = 1 * time.Second
+	maxBackoff          = 1 * time.Minute
+	maxSubmitAttempts   = 8 // after this many failed attempts a record is orphaned instead of retried forever
+)
+
+// ShardMsgFetcher resolves new CrossShardMsgs published by a source shard at
+// or after fromHeight, via whatever channel this node uses to reach that
+// shard (RPC, P2P subscription, or light-client sync). This is pollOnce's
+// one real acquisition path: unlike this node's own CrossShardPool (which
+// pollOnce feeds via AddCrossShardInfo, not reads from), it talks to the
+// source shard itself.
+type ShardMsgFetcher func(shardID common.ShardID, fromHeight uint32) ([]*types.CrossShardMsg, error)
+
+// SourceShardMsgFetcher must be wired at node startup (typically to an RPC
+// or P2P client configured per source shard) before any keeper can observe
+// real cross-shard traffic; nil causes pollOnce to fail loudly instead of
+// silently degrading into reading back this node's own pool.
+var SourceShardMsgFetcher ShardMsgFetcher
+
+// ShardTxSubmitter hands a constructed cross-shard transaction to this
+// node's own transaction pipeline (txn pool / P2P broadcast) so it is
+// packed into a future block. Wired at startup alongside
+// SourceShardMsgFetcher; nil is tolerated (the message is still recorded
+// and made visible to this shard's CrossShardPool via AddCrossShardInfo,
+// which is enough for local inclusion), but no transaction is broadcast.
+type ShardTxSubmitter func(tx *types.Transaction) error
+
+var SubmitShardTx ShardTxSubmitter
+
+// ShardStatus is the point-in-time snapshot returned by KeeperStatus for a
+// single watched shard.
+type ShardStatus struct {
+	ShardID   common.ShardID
+	Paused    bool
+	Pending   int
+	Submitted int
+	Confirmed int
+	Orphaned  int
+}
+
+// Keeper watches a single source shard, persists every CrossShardMsg it
+// observes, and drives delivery into this shard's CrossShardPool with
+// exponential backoff, rolling back and re-enqueueing rows on reorg.
+type Keeper struct {
+	lock        sync.Mutex
+	shardID     common.ShardID
+	lgr         *ledger.Ledger
+	account     *account.Account
+	store       Store
+	paused      bool
+	stopCh      chan struct{}
+	wg          sync.WaitGroup
+	fromHeight  uint32
+	pendingMsgs map[common.Uint256]*types.CrossShardMsg // in-memory retry cache; see submitPending
+}
+
+// Manager owns one Keeper per source shard listed in a CrossShardPool's
+// ShardInfo, mirroring the pool's lifecycle.
+type Manager struct {
+	lock    sync.RWMutex
+	lgr     *ledger.Ledger
+	account *account.Account
+	store   Store
+	keepers map[uint64]*Keeper
+}
+
+var keeperMgr *Manager
+
+// InitKeeperManager starts one keeper goroutine per shard already known to
+// the local CrossShardPool (via xshard.GetShardInfo), backed by a Store
+// persisted under dataDir so delivery state survives a restart, and returns
+// the manager so additional shards can be added as they are discovered.
+func InitKeeperManager(lgr *ledger.Ledger, acc *account.Account, dataDir string) (*Manager, error) {
+	store, err := NewFileStore(dataDir)
+	if err != nil {
+		return nil, fmt.Errorf("InitKeeperManager: %s", err)
+	}
+	mgr := &Manager{
+		lgr:     lgr,
+		account: acc,
+		store:   store,
+		keepers: make(map[uint64]*Keeper),
+	}
+	for shardID := range xshard.GetShardInfo() {
+		mgr.addKeeper(shardID)
+	}
+	keeperMgr = mgr
+	return mgr, nil
+}
+
+func (mgr *Manager) addKeeper(shardID common.ShardID) *Keeper {
+	mgr.lock.Lock()
+	defer mgr.lock.Unlock()
+	if k, present := mgr.keepers[shardID.ToUint64()]; present {
+		return k
+	}
+	k := &Keeper{
+		shardID:     shardID,
+		lgr:         mgr.lgr,
+		account:     mgr.account,
+		store:       mgr.store,
+		stopCh:      make(chan struct{}),
+		pendingMsgs: make(map[common.Uint256]*types.CrossShardMsg),
+	}
+	mgr.keepers[shardID.ToUint64()] = k
+	k.wg.Add(1)
+	go k.run()
+	return k
+}
+
+// KeeperStatus reports pending/submitted/orphaned counters for every watched
+// shard, for diagnostics and monitoring.
+func (mgr *Manager) KeeperStatus() []ShardStatus {
+	mgr.lock.RLock()
+	defer mgr.lock.RUnlock()
+	result := make([]ShardStatus, 0, len(mgr.keepers))
+	for _, k := range mgr.keepers {
+		k.lock.Lock()
+		result = append(result, ShardStatus{
+			ShardID:   k.shardID,
+			Paused:    k.paused,
+			Pending:   len(k.store.ListByStatus(k.shardID, StatusPending)),
+			Submitted: len(k.store.ListByStatus(k.shardID, StatusSubmitted)),
+			Confirmed: len(k.store.ListByStatus(k.shardID, StatusConfirmed)),
+			Orphaned:  len(k.store.ListByStatus(k.shardID, StatusOrphaned)),
+		})
+		k.lock.Unlock()
+	}
+	return result
+}
+
+// Resync rolls back every record at or above fromHeight for shardID and
+// resumes watching from there, used after an operator confirms a reorg or
+// after extended downtime.
+func (mgr *Manager) Resync(shardID common.ShardID, fromHeight uint32) error {
+	mgr.lock.RLock()
+	k, present := mgr.keepers[shardID.ToUint64()]
+	mgr.lock.RUnlock()
+	if !present {
+		return fmt.Errorf("keeper: no keeper for shard %v", shardID)
+	}
+	if err := k.store.DeleteFromHeight(shardID, fromHeight); err != nil {
+		return err
+	}
+	k.lock.Lock()
+	k.fromHeight = fromHeight
+	k.paused = false
+	k.lock.Unlock()
+	return nil
+}
+
+// Pause stops a shard's keeper from polling and submitting until the process
+// restarts or Resync is called.
+func (mgr *Manager) Pause(shardID common.ShardID) error {
+	mgr.lock.RLock()
+	k, present := mgr.keepers[shardID.ToUint64()]
+	mgr.lock.RUnlock()
+	if !present {
+		return fmt.Errorf("keeper: no keeper for shard %v", shardID)
+	}
+	k.lock.Lock()
+	k.paused = true
+	k.lock.Unlock()
+	return nil
+}
+
+func (k *Keeper) isPaused() bool {
+	k.lock.Lock()
+	defer k.lock.Unlock()
+	return k.paused
+}
+
+// run polls the source shard for new CrossShardMsgs, persists them, drives
+// delivery with exponential backoff, and checks for confirmation, until
+// stopped.
+func (k *Keeper) run() {
+	defer k.wg.Done()
+	ticker := time.NewTicker(defaultPollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-k.stopCh:
+			return
+		case <-ticker.C:
+			if k.isPaused() {
+				continue
+			}
+			if err := k.pollOnce(); err != nil {
+				log.Errorf("keeper shard %v: poll failed: %s", k.shardID, err)
+			}
+			k.submitPending()
+			k.checkConfirmations()
+		}
+	}
+}
+
+// pollOnce fetches new CrossShardMsgs from the source shard itself via
+// SourceShardMsgFetcher and records any not already tracked, detecting
+// reorgs along the way via observeMsg. Unlike reading this node's own
+// CrossShardPool (which pollOnce feeds, not reads from), this is a real,
+// independent acquisition path: without it the store never gains genuinely
+// new records and the keeper never drives delivery of anything this node
+// did not already know about.
+func (k *Keeper) pollOnce() error {
+	if SourceShardMsgFetcher == nil {
+		return fmt.Errorf("keeper shard %v: SourceShardMsgFetcher not configured", k.shardID)
+	}
+
+	k.lock.Lock()
+	fromHeight := k.fromHeight
+	k.lock.Unlock()
+
+	msgs, err := SourceShardMsgFetcher(k.shardID, fromHeight)
+	if err != nil {
+		return fmt.Errorf("keeper shard %v: fetch from source shard: %s", k.shardID, err)
+	}
+	for _, msg := range msgs {
+		if err := k.observeMsg(msg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// observeMsg is called for every CrossShardMsg fetched from the source
+// shard. A msg-root mismatch with an already-recorded height is treated as
+// a reorg: the affected rows are rolled back and re-enqueued from that
+// height. msg itself is cached in-process (not persisted) so submitPending
+// can retry submission without a second round trip to the source shard; if
+// that cache is lost (e.g. a restart before the first successful submit),
+// the next pollOnce simply re-fetches it, since fromHeight changes only
+// through Resync.
+func (k *Keeper) observeMsg(msg *types.CrossShardMsg) error {
+	info := msg.CrossShardMsgInfo
+	msgHeight, preMsgHash, msgRoot := info.MsgHeight, info.PreCrossShardMsgHash, info.CrossShardMsgRoot
+
+	if existing, present := k.store.Get(k.shardID, msgHeight, preMsgHash); present {
+		if existing.MsgRoot != msgRoot {
+			log.Warnf("keeper shard %v: reorg detected at height %d, rolling back", k.shardID, msgHeight)
+			if err := k.store.DeleteFromHeight(k.shardID, msgHeight); err != nil {
+				return err
+			}
+		} else {
+			k.cacheMsg(msg)
+			return nil
+		}
+	}
+	if err := k.store.Put(&Record{
+		FromShardID: k.shardID,
+		MsgHeight:   msgHeight,
+		PreMsgHash:  preMsgHash,
+		MsgRoot:     msgRoot,
+		Status:      StatusPending,
+	}); err != nil {
+		return err
+	}
+	k.cacheMsg(msg)
+	return nil
+}
+
+func (k *Keeper) cacheMsg(msg *types.CrossShardMsg) {
+	k.lock.Lock()
+	defer k.lock.Unlock()
+	k.pendingMsgs[msg.CrossShardMsgInfo.PreCrossShardMsgHash] = msg
+}
+
+// backoffDuration computes the exponential retry delay for a record that
+// has failed submission attempts times, capped at maxBackoff (and falling
+// back to maxBackoff outright if the shift overflows).
+func backoffDuration(attempts uint32) time.Duration {
+	backoff := minBackoff << attempts
+	if backoff > maxBackoff || backoff <= 0 {
+		backoff = maxBackoff
+	}
+	return backoff
+}
+
+// submitPending drives AddCrossShardInfo (making the message visible to
+// this shard's own CrossShardPool for the first time) and NewCrossShardTxMsg
+// (constructing the transaction that actually carries it into a block) for
+// every pending record, applying exponential backoff between retries and
+// orphaning a record once its failed attempts reach maxSubmitAttempts
+// instead of retrying forever.
+func (k *Keeper) submitPending() {
+	for _, rec := range k.store.ListByStatus(k.shardID, StatusPending) {
+		k.lock.Lock()
+		msg, cached := k.pendingMsgs[rec.PreMsgHash]
+		k.lock.Unlock()
+		if !cached {
+			// Lost the in-memory copy, most likely across a restart; the
+			// next pollOnce re-fetches it from the source shard.
+			continue
+		}
+
+		if err := xshard.AddCrossShardInfo(k.lgr, msg); err != nil {
+			rec.Attempts++
+			status := StatusPending
+			if rec.Attempts >= maxSubmitAttempts {
+				status = StatusOrphaned
+			}
+			k.store.UpdateStatus(k.shardID, rec.MsgHeight, rec.PreMsgHash, status, err.Error())
+			time.Sleep(backoffDuration(rec.Attempts))
+			continue
+		}
+
+		tx, err := crossshard.NewCrossShardTxMsg(k.account, rec.MsgHeight, k.shardID, config.DefConfig.Common.GasPrice, config.DefConfig.Common.GasLimit, msg.ShardMsg)
+		if err != nil {
+			log.Errorf("keeper shard %v: NewCrossShardTxMsg height %d: %s", k.shardID, rec.MsgHeight, err)
+		} else if SubmitShardTx != nil {
+			if err := SubmitShardTx(tx); err != nil {
+				log.Errorf("keeper shard %v: submit tx height %d: %s", k.shardID, rec.MsgHeight, err)
+			}
+		}
+
+		k.store.UpdateStatus(k.shardID, rec.MsgHeight, rec.PreMsgHash, StatusSubmitted, "")
+	}
+}
+
+// checkConfirmations promotes submitted records to confirmed once
+// DelCrossShardTxs has moved the matching message into this shard's
+// CrossShardPool confirmed tier, so KeeperStatus's Confirmed counter
+// actually moves instead of sitting at zero forever.
+func (k *Keeper) checkConfirmations() {
+	content := xshard.Content(k.shardID)
+	if len(content.Confirmed) == 0 {
+		return
+	}
+	confirmedHashes := make(map[common.Uint256]bool, len(content.Confirmed))
+	for _, msg := range content.Confirmed {
+		confirmedHashes[msg.CrossShardMsgInfo.PreCrossShardMsgHash] = true
+	}
+	for _, rec := range k.store.ListByStatus(k.shardID, StatusSubmitted) {
+		if confirmedHashes[rec.PreMsgHash] {
+			k.store.UpdateStatus(k.shardID, rec.MsgHeight, rec.PreMsgHash, StatusConfirmed, "")
+			k.lock.Lock()
+			delete(k.pendingMsgs, rec.PreMsgHash)
+			k.lock.Unlock()
+		}
+	}
+}
+
+// Stop terminates every shard keeper owned by mgr and waits for their
+// goroutines to exit.
+func (mgr *Manager) Stop() {
+	mgr.lock.RLock()
+	keepers := make([]*Keeper, 0, len(mgr.keepers))
+	for _, k := range mgr.keepers {
+		keepers = append(keepers, k)
+	}
+	mgr.lock.RUnlock()
+	for _, k := range keepers {
+		close(k.stopCh)
+		k.wg.Wait()
+	}
+}
@@ -0,0 +1,240 @@
+/*
+ * Copyright (C) 2019 The ontology Authors
+ * This file is part of The ontology library.
+ *
+ * The ontology is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The ontology is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public License
+ * along with The ontology.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package keeper
+
+import (
+	"testing"
+
+	"github.com/ontio/ontology/common"
+	"github.com/ontio/ontology/core/chainmgr/xshard"
+	"github.com/ontio/ontology/core/types"
+)
+
+// newTestManager builds a Manager/Keeper pair directly (bypassing
+// addKeeper, which spawns a goroutine that dereferences a real
+// *ledger.Ledger/*account.Account this package has no way to construct in a
+// unit test) so the pure bookkeeping logic below — status transitions,
+// pause/resync, status aggregation — can be exercised without a running
+// node.
+func newTestManager(t testing.TB) (*Manager, common.ShardID, *Keeper) {
+	t.Helper()
+	shardID := testShardID(t, 1)
+	store := NewMemStore()
+	k := &Keeper{
+		shardID:     shardID,
+		store:       store,
+		stopCh:      make(chan struct{}),
+		pendingMsgs: make(map[common.Uint256]*types.CrossShardMsg),
+	}
+	mgr := &Manager{
+		store:   store,
+		keepers: map[uint64]*Keeper{shardID.ToUint64(): k},
+	}
+	return mgr, shardID, k
+}
+
+func TestBackoffDurationCapsAtMaxBackoff(t *testing.T) {
+	if got := backoffDuration(0); got != minBackoff {
+		t.Fatalf("backoffDuration(0) = %s, want %s", got, minBackoff)
+	}
+	if got := backoffDuration(1); got != 2*minBackoff {
+		t.Fatalf("backoffDuration(1) = %s, want %s", got, 2*minBackoff)
+	}
+	if got := backoffDuration(30); got != maxBackoff {
+		t.Fatalf("backoffDuration(30) = %s, want %s (should cap rather than overflow)", got, maxBackoff)
+	}
+}
+
+func TestManagerPauseStopsNewWork(t *testing.T) {
+	mgr, shardID, k := newTestManager(t)
+	if k.isPaused() {
+		t.Fatalf("isPaused: expected false before Pause")
+	}
+	if err := mgr.Pause(shardID); err != nil {
+		t.Fatalf("Pause: %s", err)
+	}
+	if !k.isPaused() {
+		t.Fatalf("isPaused: expected true after Pause")
+	}
+}
+
+func TestManagerPauseUnknownShardErrors(t *testing.T) {
+	mgr, _, _ := newTestManager(t)
+	other := testShardID(t, 2)
+	if err := mgr.Pause(other); err == nil {
+		t.Fatalf("Pause: expected error for a shard with no keeper, got nil")
+	}
+}
+
+func TestManagerResyncRollsBackAndResetsFromHeight(t *testing.T) {
+	mgr, shardID, k := newTestManager(t)
+	if err := k.store.Put(&Record{
+		FromShardID: shardID,
+		MsgHeight:   5,
+		PreMsgHash:  testHash(t, "pre"),
+		MsgRoot:     testHash(t, "root"),
+		Status:      StatusPending,
+	}); err != nil {
+		t.Fatalf("Put: %s", err)
+	}
+
+	if err := mgr.Resync(shardID, 5); err != nil {
+		t.Fatalf("Resync: %s", err)
+	}
+	if list := k.store.ListByShard(shardID); len(list) != 0 {
+		t.Fatalf("ListByShard after Resync = %d, want 0 (record at fromHeight rolled back)", len(list))
+	}
+	k.lock.Lock()
+	fromHeight := k.fromHeight
+	k.lock.Unlock()
+	if fromHeight != 5 {
+		t.Fatalf("fromHeight after Resync = %d, want 5", fromHeight)
+	}
+}
+
+func TestKeeperStatusAggregatesCountsPerShard(t *testing.T) {
+	mgr, shardID, k := newTestManager(t)
+	records := []*Record{
+		{FromShardID: shardID, MsgHeight: 1, PreMsgHash: testHash(t, "a"), Status: StatusPending},
+		{FromShardID: shardID, MsgHeight: 2, PreMsgHash: testHash(t, "b"), Status: StatusSubmitted},
+		{FromShardID: shardID, MsgHeight: 3, PreMsgHash: testHash(t, "c"), Status: StatusConfirmed},
+		{FromShardID: shardID, MsgHeight: 4, PreMsgHash: testHash(t, "d"), Status: StatusOrphaned},
+	}
+	for _, rec := range records {
+		if err := k.store.Put(rec); err != nil {
+			t.Fatalf("Put: %s", err)
+		}
+	}
+
+	status := mgr.KeeperStatus()
+	if len(status) != 1 {
+		t.Fatalf("KeeperStatus: %d entries, want 1", len(status))
+	}
+	got := status[0]
+	if got.Pending != 1 || got.Submitted != 1 || got.Confirmed != 1 || got.Orphaned != 1 {
+		t.Fatalf("KeeperStatus = %+v, want 1 of each", got)
+	}
+}
+
+func TestCheckConfirmationsNoopWhenShardUnknownToPool(t *testing.T) {
+	_, _, k := newTestManager(t)
+	xshard.InitCrossShardPool(testShardID(t, 2), 0)
+	if err := k.store.Put(&Record{
+		FromShardID: k.shardID,
+		MsgHeight:   1,
+		PreMsgHash:  testHash(t, "pre"),
+		MsgRoot:     testHash(t, "root"),
+		Status:      StatusSubmitted,
+	}); err != nil {
+		t.Fatalf("Put: %s", err)
+	}
+
+	// xshard.Content returns a zero-value QueueContent for a shard the pool
+	// has never heard of; checkConfirmations must neither panic nor promote
+	// the record on that empty result.
+	k.checkConfirmations()
+
+	submitted := k.store.ListByStatus(k.shardID, StatusSubmitted)
+	if len(submitted) != 1 {
+		t.Fatalf("ListByStatus(submitted) = %d, want 1 (unchanged)", len(submitted))
+	}
+}
+
+func TestPollOnceFailsLoudlyWithoutFetcherConfigured(t *testing.T) {
+	_, _, k := newTestManager(t)
+	prev := SourceShardMsgFetcher
+	SourceShardMsgFetcher = nil
+	defer func() { SourceShardMsgFetcher = prev }()
+
+	if err := k.pollOnce(); err == nil {
+		t.Fatalf("pollOnce: expected error with no SourceShardMsgFetcher configured, got nil")
+	}
+}
+
+func TestPollOnceRecordsMessagesFromFetcher(t *testing.T) {
+	_, shardID, k := newTestManager(t)
+	preHash := testHash(t, "pre")
+	root := testHash(t, "root")
+	prev := SourceShardMsgFetcher
+	SourceShardMsgFetcher = func(gotShardID common.ShardID, fromHeight uint32) ([]*types.CrossShardMsg, error) {
+		if gotShardID != shardID {
+			t.Fatalf("SourceShardMsgFetcher shardID = %v, want %v", gotShardID, shardID)
+		}
+		return []*types.CrossShardMsg{
+			{CrossShardMsgInfo: &types.CrossShardMsgInfo{
+				MsgHeight:            3,
+				PreCrossShardMsgHash: preHash,
+				CrossShardMsgRoot:    root,
+			}},
+		}, nil
+	}
+	defer func() { SourceShardMsgFetcher = prev }()
+
+	if err := k.pollOnce(); err != nil {
+		t.Fatalf("pollOnce: %s", err)
+	}
+
+	rec, present := k.store.Get(shardID, 3, preHash)
+	if !present {
+		t.Fatalf("Get: expected pollOnce to have recorded the fetched message")
+	}
+	if rec.Status != StatusPending || rec.MsgRoot != root {
+		t.Fatalf("Get = %+v, want pending with root %s", rec, root.ToHexString())
+	}
+
+	k.lock.Lock()
+	_, cached := k.pendingMsgs[preHash]
+	k.lock.Unlock()
+	if !cached {
+		t.Fatalf("pendingMsgs: expected the fetched message to be cached for submitPending's retry")
+	}
+}
+
+func TestObserveMsgDetectsReorgAndRollsBack(t *testing.T) {
+	_, shardID, k := newTestManager(t)
+	preHash := testHash(t, "pre")
+	msg1 := &types.CrossShardMsg{CrossShardMsgInfo: &types.CrossShardMsgInfo{
+		MsgHeight:            3,
+		PreCrossShardMsgHash: preHash,
+		CrossShardMsgRoot:    testHash(t, "root1"),
+	}}
+	if err := k.observeMsg(msg1); err != nil {
+		t.Fatalf("observeMsg(msg1): %s", err)
+	}
+
+	// A second message at the same height with a different root is a
+	// source-shard reorg: the stale record must be rolled back, not kept
+	// alongside the new one.
+	msg2 := &types.CrossShardMsg{CrossShardMsgInfo: &types.CrossShardMsgInfo{
+		MsgHeight:            3,
+		PreCrossShardMsgHash: preHash,
+		CrossShardMsgRoot:    testHash(t, "root2"),
+	}}
+	if err := k.observeMsg(msg2); err != nil {
+		t.Fatalf("observeMsg(msg2): %s", err)
+	}
+
+	rec, present := k.store.Get(shardID, 3, preHash)
+	if !present {
+		t.Fatalf("Get: expected the reorg'd record to have been re-recorded")
+	}
+	if rec.MsgRoot != testHash(t, "root2") {
+		t.Fatalf("Get.MsgRoot = %s, want the post-reorg root", rec.MsgRoot.ToHexString())
+	}
+}
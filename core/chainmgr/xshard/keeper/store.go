@@ -0,0 +1,373 @@
+/*
+ * Copyright (C) 2019 The ontology Authors
+ * This file is part of The ontology library.
+ *
+ * The ontology is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The ontology is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public License
+ * along with The ontology.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+// Package keeper implements a federation-style "chain keeper" for cross-shard
+// message delivery: it watches a source shard, durably records every message
+// it observes, and drives outbound submission with retry and reorg handling.
+package keeper
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/ontio/ontology/common"
+	"github.com/ontio/ontology/common/serialization"
+)
+
+// DeliveryStatus tracks a watched CrossShardMsg through its delivery lifecycle.
+type DeliveryStatus uint8
+
+const (
+	StatusPending DeliveryStatus = iota
+	StatusSubmitted
+	StatusConfirmed
+	StatusOrphaned
+)
+
+func (s DeliveryStatus) String() string {
+	switch s {
+	case StatusPending:
+		return "pending"
+	case StatusSubmitted:
+		return "submitted"
+	case StatusConfirmed:
+		return "confirmed"
+	case StatusOrphaned:
+		return "orphaned"
+	default:
+		return "unknown"
+	}
+}
+
+// Record is one row of the keeper's delivery ledger, keyed by
+// (FromShardID, MsgHeight, PreCrossShardMsgHash).
+type Record struct {
+	FromShardID common.ShardID
+	MsgHeight   uint32
+	PreMsgHash  common.Uint256
+	MsgRoot     common.Uint256
+	Status      DeliveryStatus
+	Attempts    uint32
+	LastErr     string
+}
+
+// Serialize/Deserialize give Record the same binary wire form used
+// elsewhere in this series, so fileStore can persist it without reaching
+// into core/ledger internals this package has no access to.
+func (rec *Record) Serialize(w io.Writer) error {
+	if err := rec.FromShardID.Serialize(w); err != nil {
+		return fmt.Errorf("serialize Record.FromShardID: %s", err)
+	}
+	if err := serialization.WriteUint32(w, rec.MsgHeight); err != nil {
+		return fmt.Errorf("serialize Record.MsgHeight: %s", err)
+	}
+	if err := rec.PreMsgHash.Serialize(w); err != nil {
+		return fmt.Errorf("serialize Record.PreMsgHash: %s", err)
+	}
+	if err := rec.MsgRoot.Serialize(w); err != nil {
+		return fmt.Errorf("serialize Record.MsgRoot: %s", err)
+	}
+	if err := serialization.WriteByte(w, byte(rec.Status)); err != nil {
+		return fmt.Errorf("serialize Record.Status: %s", err)
+	}
+	if err := serialization.WriteUint32(w, rec.Attempts); err != nil {
+		return fmt.Errorf("serialize Record.Attempts: %s", err)
+	}
+	if err := serialization.WriteString(w, rec.LastErr); err != nil {
+		return fmt.Errorf("serialize Record.LastErr: %s", err)
+	}
+	return nil
+}
+
+func (rec *Record) Deserialize(r io.Reader) error {
+	if err := rec.FromShardID.Deserialize(r); err != nil {
+		return fmt.Errorf("deserialize Record.FromShardID: %s", err)
+	}
+	msgHeight, err := serialization.ReadUint32(r)
+	if err != nil {
+		return fmt.Errorf("deserialize Record.MsgHeight: %s", err)
+	}
+	rec.MsgHeight = msgHeight
+	if err := rec.PreMsgHash.Deserialize(r); err != nil {
+		return fmt.Errorf("deserialize Record.PreMsgHash: %s", err)
+	}
+	if err := rec.MsgRoot.Deserialize(r); err != nil {
+		return fmt.Errorf("deserialize Record.MsgRoot: %s", err)
+	}
+	status, err := serialization.ReadByte(r)
+	if err != nil {
+		return fmt.Errorf("deserialize Record.Status: %s", err)
+	}
+	rec.Status = DeliveryStatus(status)
+	attempts, err := serialization.ReadUint32(r)
+	if err != nil {
+		return fmt.Errorf("deserialize Record.Attempts: %s", err)
+	}
+	rec.Attempts = attempts
+	lastErr, err := serialization.ReadString(r)
+	if err != nil {
+		return fmt.Errorf("deserialize Record.LastErr: %s", err)
+	}
+	rec.LastErr = lastErr
+	return nil
+}
+
+type recordKey struct {
+	fromShardID uint64
+	msgHeight   uint32
+	preMsgHash  common.Uint256
+}
+
+// Store is the durable record of every cross-shard message a keeper has
+// observed. memStore (in-process only, for tests) and fileStore (persists
+// every write to disk so it survives a restart) both implement it; swapping
+// in a real ORM-backed table later is a matter of implementing Store.
+type Store interface {
+	Put(rec *Record) error
+	Get(fromShardID common.ShardID, msgHeight uint32, preMsgHash common.Uint256) (*Record, bool)
+	ListByShard(fromShardID common.ShardID) []*Record
+	ListByStatus(fromShardID common.ShardID, status DeliveryStatus) []*Record
+	UpdateStatus(fromShardID common.ShardID, msgHeight uint32, preMsgHash common.Uint256, status DeliveryStatus, lastErr string) error
+	// DeleteFromHeight removes every record at or above fromHeight for a
+	// shard, used to roll back rows observed on an abandoned fork.
+	DeleteFromHeight(fromShardID common.ShardID, fromHeight uint32) error
+}
+
+type memStore struct {
+	lock    sync.RWMutex
+	records map[recordKey]*Record
+}
+
+func NewMemStore() Store {
+	return &memStore{
+		records: make(map[recordKey]*Record),
+	}
+}
+
+func toKey(fromShardID common.ShardID, msgHeight uint32, preMsgHash common.Uint256) recordKey {
+	return recordKey{fromShardID: fromShardID.ToUint64(), msgHeight: msgHeight, preMsgHash: preMsgHash}
+}
+
+func (s *memStore) Put(rec *Record) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.records[toKey(rec.FromShardID, rec.MsgHeight, rec.PreMsgHash)] = rec
+	return nil
+}
+
+func (s *memStore) Get(fromShardID common.ShardID, msgHeight uint32, preMsgHash common.Uint256) (*Record, bool) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	rec, present := s.records[toKey(fromShardID, msgHeight, preMsgHash)]
+	return rec, present
+}
+
+func (s *memStore) ListByShard(fromShardID common.ShardID) []*Record {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	result := make([]*Record, 0)
+	for key, rec := range s.records {
+		if key.fromShardID == fromShardID.ToUint64() {
+			result = append(result, rec)
+		}
+	}
+	return result
+}
+
+func (s *memStore) ListByStatus(fromShardID common.ShardID, status DeliveryStatus) []*Record {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	result := make([]*Record, 0)
+	for key, rec := range s.records {
+		if key.fromShardID == fromShardID.ToUint64() && rec.Status == status {
+			result = append(result, rec)
+		}
+	}
+	return result
+}
+
+func (s *memStore) UpdateStatus(fromShardID common.ShardID, msgHeight uint32, preMsgHash common.Uint256, status DeliveryStatus, lastErr string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	key := toKey(fromShardID, msgHeight, preMsgHash)
+	rec, present := s.records[key]
+	if !present {
+		return fmt.Errorf("keeper store: no record for shard %v height %d hash %s", fromShardID, msgHeight, preMsgHash.ToHexString())
+	}
+	rec.Status = status
+	rec.LastErr = lastErr
+	return nil
+}
+
+func (s *memStore) DeleteFromHeight(fromShardID common.ShardID, fromHeight uint32) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for key := range s.records {
+		if key.fromShardID == fromShardID.ToUint64() && key.msgHeight >= fromHeight {
+			delete(s.records, key)
+		}
+	}
+	return nil
+}
+
+// fileStore is a durable Store: every Put/UpdateStatus/DeleteFromHeight call
+// is written through to one file per record under baseDir before the
+// in-process cache is updated, so a keeper's delivery state survives a
+// restart instead of resetting to empty (which previously made every
+// record, and the reorg detection in observeMsg that depends on them, reset
+// every time the node restarted).
+type fileStore struct {
+	lock    sync.RWMutex
+	baseDir string
+	records map[recordKey]*Record
+}
+
+// NewFileStore opens (creating if necessary) a keeper store rooted at
+// baseDir and hydrates its in-process cache from whatever records are
+// already on disk.
+func NewFileStore(baseDir string) (Store, error) {
+	if err := os.MkdirAll(baseDir, 0750); err != nil {
+		return nil, fmt.Errorf("NewFileStore: create %s: %s", baseDir, err)
+	}
+	s := &fileStore{
+		baseDir: baseDir,
+		records: make(map[recordKey]*Record),
+	}
+	entries, err := ioutil.ReadDir(baseDir)
+	if err != nil {
+		return nil, fmt.Errorf("NewFileStore: read %s: %s", baseDir, err)
+	}
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != ".rec" {
+			continue
+		}
+		payload, err := ioutil.ReadFile(filepath.Join(baseDir, entry.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("NewFileStore: read %s: %s", entry.Name(), err)
+		}
+		rec := &Record{}
+		if err := rec.Deserialize(bytes.NewBuffer(payload)); err != nil {
+			return nil, fmt.Errorf("NewFileStore: decode %s: %s", entry.Name(), err)
+		}
+		s.records[toKey(rec.FromShardID, rec.MsgHeight, rec.PreMsgHash)] = rec
+	}
+	return s, nil
+}
+
+func recordFileName(key recordKey) string {
+	return fmt.Sprintf("%d-%d-%s.rec", key.fromShardID, key.msgHeight, key.preMsgHash.ToHexString())
+}
+
+// persistLocked atomically writes rec to its file: write to a temp file in
+// the same directory, then rename, so a crash mid-write never leaves a
+// corrupt record behind.
+func (s *fileStore) persistLocked(rec *Record) error {
+	buf := new(bytes.Buffer)
+	if err := rec.Serialize(buf); err != nil {
+		return fmt.Errorf("keeper file store: encode record: %s", err)
+	}
+	key := toKey(rec.FromShardID, rec.MsgHeight, rec.PreMsgHash)
+	path := filepath.Join(s.baseDir, recordFileName(key))
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, buf.Bytes(), 0640); err != nil {
+		return fmt.Errorf("keeper file store: write %s: %s", tmp, err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("keeper file store: rename %s: %s", tmp, err)
+	}
+	return nil
+}
+
+func (s *fileStore) Put(rec *Record) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if err := s.persistLocked(rec); err != nil {
+		return err
+	}
+	s.records[toKey(rec.FromShardID, rec.MsgHeight, rec.PreMsgHash)] = rec
+	return nil
+}
+
+func (s *fileStore) Get(fromShardID common.ShardID, msgHeight uint32, preMsgHash common.Uint256) (*Record, bool) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	rec, present := s.records[toKey(fromShardID, msgHeight, preMsgHash)]
+	return rec, present
+}
+
+func (s *fileStore) ListByShard(fromShardID common.ShardID) []*Record {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	result := make([]*Record, 0)
+	for key, rec := range s.records {
+		if key.fromShardID == fromShardID.ToUint64() {
+			result = append(result, rec)
+		}
+	}
+	return result
+}
+
+func (s *fileStore) ListByStatus(fromShardID common.ShardID, status DeliveryStatus) []*Record {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	result := make([]*Record, 0)
+	for key, rec := range s.records {
+		if key.fromShardID == fromShardID.ToUint64() && rec.Status == status {
+			result = append(result, rec)
+		}
+	}
+	return result
+}
+
+func (s *fileStore) UpdateStatus(fromShardID common.ShardID, msgHeight uint32, preMsgHash common.Uint256, status DeliveryStatus, lastErr string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	key := toKey(fromShardID, msgHeight, preMsgHash)
+	rec, present := s.records[key]
+	if !present {
+		return fmt.Errorf("keeper file store: no record for shard %v height %d hash %s", fromShardID, msgHeight, preMsgHash.ToHexString())
+	}
+	updated := *rec
+	updated.Status = status
+	updated.LastErr = lastErr
+	if err := s.persistLocked(&updated); err != nil {
+		return err
+	}
+	*rec = updated
+	return nil
+}
+
+func (s *fileStore) DeleteFromHeight(fromShardID common.ShardID, fromHeight uint32) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for key := range s.records {
+		if key.fromShardID == fromShardID.ToUint64() && key.msgHeight >= fromHeight {
+			path := filepath.Join(s.baseDir, recordFileName(key))
+			if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+				return fmt.Errorf("keeper file store: remove %s: %s", path, err)
+			}
+			delete(s.records, key)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,188 @@
+/*
+ * Copyright (C) 2019 The ontology Authors
+ * This file is part of The ontology library.
+ *
+ * The ontology is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The ontology is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public License
+ * along with The ontology.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package keeper
+
+import (
+	"crypto/sha256"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/ontio/ontology/common"
+)
+
+func testShardID(t testing.TB, id uint64) common.ShardID {
+	shardID, err := common.NewShardID(id)
+	if err != nil {
+		t.Fatalf("common.NewShardID(%d): %s", id, err)
+	}
+	return shardID
+}
+
+func testHash(t testing.TB, seed string) common.Uint256 {
+	return common.Uint256(sha256.Sum256([]byte(seed)))
+}
+
+func TestDeliveryStatusString(t *testing.T) {
+	cases := []struct {
+		status DeliveryStatus
+		want   string
+	}{
+		{StatusPending, "pending"},
+		{StatusSubmitted, "submitted"},
+		{StatusConfirmed, "confirmed"},
+		{StatusOrphaned, "orphaned"},
+		{DeliveryStatus(99), "unknown"},
+	}
+	for _, c := range cases {
+		if got := c.status.String(); got != c.want {
+			t.Fatalf("DeliveryStatus(%d).String() = %q, want %q", c.status, got, c.want)
+		}
+	}
+}
+
+func TestFileStorePutGetAndListByStatus(t *testing.T) {
+	dir, err := ioutil.TempDir("", "keeper-store-test")
+	if err != nil {
+		t.Fatalf("TempDir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	store, err := NewFileStore(dir)
+	if err != nil {
+		t.Fatalf("NewFileStore: %s", err)
+	}
+	shardID := testShardID(t, 1)
+	rec := &Record{
+		FromShardID: shardID,
+		MsgHeight:   5,
+		PreMsgHash:  testHash(t, "pre"),
+		MsgRoot:     testHash(t, "root"),
+		Status:      StatusPending,
+	}
+	if err := store.Put(rec); err != nil {
+		t.Fatalf("Put: %s", err)
+	}
+
+	got, present := store.Get(shardID, 5, testHash(t, "pre"))
+	if !present {
+		t.Fatalf("Get: expected record to be found")
+	}
+	if got.MsgRoot != rec.MsgRoot {
+		t.Fatalf("Get.MsgRoot = %s, want %s", got.MsgRoot.ToHexString(), rec.MsgRoot.ToHexString())
+	}
+
+	if pending := store.ListByStatus(shardID, StatusPending); len(pending) != 1 {
+		t.Fatalf("ListByStatus(pending) = %d, want 1", len(pending))
+	}
+	if submitted := store.ListByStatus(shardID, StatusSubmitted); len(submitted) != 0 {
+		t.Fatalf("ListByStatus(submitted) = %d, want 0", len(submitted))
+	}
+}
+
+func TestFileStoreUpdateStatusPersistsAcrossReopen(t *testing.T) {
+	dir, err := ioutil.TempDir("", "keeper-store-test")
+	if err != nil {
+		t.Fatalf("TempDir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	store, err := NewFileStore(dir)
+	if err != nil {
+		t.Fatalf("NewFileStore: %s", err)
+	}
+	shardID := testShardID(t, 1)
+	preHash := testHash(t, "pre")
+	rec := &Record{
+		FromShardID: shardID,
+		MsgHeight:   5,
+		PreMsgHash:  preHash,
+		MsgRoot:     testHash(t, "root"),
+		Status:      StatusPending,
+	}
+	if err := store.Put(rec); err != nil {
+		t.Fatalf("Put: %s", err)
+	}
+	if err := store.UpdateStatus(shardID, 5, preHash, StatusSubmitted, ""); err != nil {
+		t.Fatalf("UpdateStatus: %s", err)
+	}
+
+	// A fresh store instance pointed at the same directory simulates a
+	// restart: the hydrated cache must reflect the last persisted status,
+	// not the one Put originally wrote.
+	reopened, err := NewFileStore(dir)
+	if err != nil {
+		t.Fatalf("NewFileStore (reopen): %s", err)
+	}
+	got, present := reopened.Get(shardID, 5, preHash)
+	if !present {
+		t.Fatalf("Get after reopen: expected record to be found")
+	}
+	if got.Status != StatusSubmitted {
+		t.Fatalf("Get after reopen: Status = %s, want %s", got.Status, StatusSubmitted)
+	}
+}
+
+func TestFileStoreDeleteFromHeightRemovesOnlyAtOrAbove(t *testing.T) {
+	dir, err := ioutil.TempDir("", "keeper-store-test")
+	if err != nil {
+		t.Fatalf("TempDir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	store, err := NewFileStore(dir)
+	if err != nil {
+		t.Fatalf("NewFileStore: %s", err)
+	}
+	shardID := testShardID(t, 1)
+	for _, height := range []uint32{4, 5, 6} {
+		rec := &Record{
+			FromShardID: shardID,
+			MsgHeight:   height,
+			PreMsgHash:  testHash(t, "pre"),
+			MsgRoot:     testHash(t, "root"),
+			Status:      StatusPending,
+		}
+		if err := store.Put(rec); err != nil {
+			t.Fatalf("Put height %d: %s", height, err)
+		}
+	}
+
+	if err := store.DeleteFromHeight(shardID, 5); err != nil {
+		t.Fatalf("DeleteFromHeight: %s", err)
+	}
+
+	if _, present := store.Get(shardID, 4, testHash(t, "pre")); !present {
+		t.Fatalf("Get height 4: expected record below fromHeight to survive")
+	}
+	if _, present := store.Get(shardID, 5, testHash(t, "pre")); present {
+		t.Fatalf("Get height 5: expected record at fromHeight to be removed")
+	}
+	if _, present := store.Get(shardID, 6, testHash(t, "pre")); present {
+		t.Fatalf("Get height 6: expected record above fromHeight to be removed")
+	}
+
+	reopened, err := NewFileStore(dir)
+	if err != nil {
+		t.Fatalf("NewFileStore (reopen): %s", err)
+	}
+	if list := reopened.ListByShard(shardID); len(list) != 1 {
+		t.Fatalf("ListByShard after reopen = %d, want 1 (deletion persisted to disk)", len(list))
+	}
+}
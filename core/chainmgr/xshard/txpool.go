@@ -0,0 +1,362 @@
+/*
+ * Copyright (C) 2019 The ontology Authors
+ * This file is part of The ontology library.
+ *
+ * The ontology is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The ontology is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public License
+ * along with The ontology.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package xshard
+
+import (
+	"container/heap"
+	"sync"
+
+	"github.com/ontio/ontology/common"
+	"github.com/ontio/ontology/core/types"
+)
+
+// pendingEntry is one message waiting in a ShardTxQueue's pending heap,
+// ready to be handed to GetCrossShardTxs in (MsgHeight, arrival) order.
+type pendingEntry struct {
+	msg     *types.CrossShardMsg
+	arrival uint64
+	index   int
+}
+
+// pendingHeap is a container/heap min-heap ordered by (MsgHeight, arrival),
+// so messages are always surfaced in the order they should be included.
+type pendingHeap []*pendingEntry
+
+func (h pendingHeap) Len() int { return len(h) }
+
+func (h pendingHeap) Less(i, j int) bool {
+	hi, hj := h[i].msg.CrossShardMsgInfo.MsgHeight, h[j].msg.CrossShardMsgInfo.MsgHeight
+	if hi != hj {
+		return hi < hj
+	}
+	return h[i].arrival < h[j].arrival
+}
+
+func (h pendingHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index, h[j].index = i, j
+}
+
+func (h *pendingHeap) Push(x interface{}) {
+	entry := x.(*pendingEntry)
+	entry.index = len(*h)
+	*h = append(*h, entry)
+}
+
+func (h *pendingHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	entry := old[n-1]
+	old[n-1] = nil
+	entry.index = -1
+	*h = old[:n-1]
+	return entry
+}
+
+// QueueStats is the point-in-time counters returned by ShardTxQueue.Stats.
+type QueueStats struct {
+	Pending       int
+	Queued        int
+	Confirmed     int
+	Evicted       uint64
+	QueuedEvicted uint64
+}
+
+// QueueContent mirrors a standard txpool's Content() inspection call: every
+// message currently held, split by which tier it is in.
+type QueueContent struct {
+	Pending   []*types.CrossShardMsg
+	Queued    []*types.CrossShardMsg
+	Confirmed []*types.CrossShardMsg
+}
+
+// ShardTxQueue holds every CrossShardMsg known from one source shard, keyed
+// by PreCrossShardMsgHash, split into three tiers; queued and confirmed are
+// each bounded by maxCap with oldest-first eviction, pending is not bounded:
+//   - pending: linked to the known chain head, ready for GetCrossShardTxs
+//   - queued: arrived out of order, predecessor not linked yet
+//   - confirmed: already delivered via DelCrossShardTxs
+//
+// This mirrors a light-client tx pool's pending/queued split plus an
+// eviction-bounded recently-confirmed tier. queued is bounded the same way
+// as confirmed: a stalled or malicious predecessor must not let an attacker
+// grow this map without bound.
+type ShardTxQueue struct {
+	lock sync.Mutex
+
+	shardID uint64
+	maxCap  uint32
+
+	pending      pendingHeap
+	pendingIndex map[common.Uint256]*pendingEntry
+	queued       map[common.Uint256]*types.CrossShardMsg
+	queuedOrder  []common.Uint256 // oldest-first, for LRU eviction
+	confirmed    map[common.Uint256]*types.CrossShardMsg
+	confirmOrder []common.Uint256 // oldest-first, for LRU eviction
+
+	headHash common.Uint256 // PreCrossShardMsgHash expected to link next
+	tailRoot common.Uint256 // CrossShardMsgRoot of the last linked message
+	hasTail  bool
+
+	nextArrival   uint64
+	evicted       uint64
+	queuedEvicted uint64
+}
+
+func newShardTxQueue(shardID uint64, maxCap uint32, headHash common.Uint256) *ShardTxQueue {
+	return &ShardTxQueue{
+		shardID:      shardID,
+		maxCap:       maxCap,
+		pendingIndex: make(map[common.Uint256]*pendingEntry),
+		queued:       make(map[common.Uint256]*types.CrossShardMsg),
+		confirmed:    make(map[common.Uint256]*types.CrossShardMsg),
+		headHash:     headHash,
+	}
+}
+
+// linkHash reports the hash a newly-arrived message must carry as its
+// PreCrossShardMsgHash in order to be immediately ready (pending): either the
+// still-unconsumed chain head, or the root left behind by the last message
+// already accepted into pending/confirmed this session.
+func (q *ShardTxQueue) linkHash() common.Uint256 {
+	if q.hasTail {
+		return q.tailRoot
+	}
+	return q.headHash
+}
+
+// Add inserts msg keyed by its PreCrossShardMsgHash, placing it in pending if
+// it links onto the known chain, or queued otherwise. Re-adding an
+// already-known hash is a no-op, matching the previous pool's idempotency.
+func (q *ShardTxQueue) Add(msg *types.CrossShardMsg) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	q.addLocked(msg)
+}
+
+func (q *ShardTxQueue) addLocked(msg *types.CrossShardMsg) {
+	preHash := msg.CrossShardMsgInfo.PreCrossShardMsgHash
+	if _, present := q.pendingIndex[preHash]; present {
+		return
+	}
+	if _, present := q.queued[preHash]; present {
+		return
+	}
+	if _, present := q.confirmed[preHash]; present {
+		return
+	}
+
+	if preHash == q.linkHash() {
+		q.pushPending(msg)
+		q.promoteLocked()
+		return
+	}
+	q.queued[preHash] = msg
+	q.queuedOrder = append(q.queuedOrder, preHash)
+	q.evictQueuedLocked()
+}
+
+// evictQueuedLocked bounds queued the same way evictLocked bounds confirmed:
+// a burst of valid-but-out-of-order messages, or a predecessor that never
+// arrives, must not grow queued without bound.
+func (q *ShardTxQueue) evictQueuedLocked() {
+	if q.maxCap == 0 {
+		return
+	}
+	for uint32(len(q.queued)) > q.maxCap && len(q.queuedOrder) > 0 {
+		oldest := q.queuedOrder[0]
+		q.queuedOrder = q.queuedOrder[1:]
+		if _, present := q.queued[oldest]; present {
+			delete(q.queued, oldest)
+			q.queuedEvicted++
+		}
+	}
+}
+
+func (q *ShardTxQueue) pushPending(msg *types.CrossShardMsg) {
+	entry := &pendingEntry{msg: msg, arrival: q.nextArrival}
+	q.nextArrival++
+	heap.Push(&q.pending, entry)
+	q.pendingIndex[msg.CrossShardMsgInfo.PreCrossShardMsgHash] = entry
+	q.tailRoot = msg.CrossShardMsgInfo.CrossShardMsgRoot
+	q.hasTail = true
+}
+
+// promoteLocked moves queued messages whose predecessor hash is now linked
+// into pending, repeating until no further queued message can be promoted.
+func (q *ShardTxQueue) promoteLocked() {
+	for {
+		next, present := q.queued[q.linkHash()]
+		if !present {
+			return
+		}
+		delete(q.queued, next.CrossShardMsgInfo.PreCrossShardMsgHash)
+		q.pushPending(next)
+	}
+}
+
+// Pending returns the messages currently ready for inclusion, in
+// (MsgHeight, arrival) order, without mutating the underlying heap.
+func (q *ShardTxQueue) Pending() []*types.CrossShardMsg {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	cpy := make(pendingHeap, len(q.pending))
+	copy(cpy, q.pending)
+	ordered := make([]*types.CrossShardMsg, 0, len(cpy))
+	for cpy.Len() > 0 {
+		entry := heap.Pop(&cpy).(*pendingEntry)
+		ordered = append(ordered, entry.msg)
+	}
+	return ordered
+}
+
+// Confirm removes the message keyed by preMsgHash from pending and records it
+// confirmed, advancing the chain head to that message's CrossShardMsgRoot.
+// Queued messages are promoted once they link onto linkHash(), which while
+// pending has a tail is the last pending root rather than the head.
+// Confirmed entries beyond MaxBlockCap are evicted oldest-first.
+func (q *ShardTxQueue) Confirm(preMsgHash common.Uint256) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	entry, present := q.pendingIndex[preMsgHash]
+	if !present {
+		return
+	}
+	heap.Remove(&q.pending, entry.index)
+	delete(q.pendingIndex, preMsgHash)
+
+	q.confirmed[preMsgHash] = entry.msg
+	q.confirmOrder = append(q.confirmOrder, preMsgHash)
+	q.headHash = entry.msg.CrossShardMsgInfo.CrossShardMsgRoot
+
+	q.evictLocked()
+	q.promoteLocked()
+}
+
+func (q *ShardTxQueue) evictLocked() {
+	if q.maxCap == 0 {
+		return
+	}
+	for uint32(len(q.confirmed)) > q.maxCap && len(q.confirmOrder) > 0 {
+		oldest := q.confirmOrder[0]
+		q.confirmOrder = q.confirmOrder[1:]
+		if _, present := q.confirmed[oldest]; present {
+			delete(q.confirmed, oldest)
+			q.evicted++
+		}
+	}
+}
+
+// Lookup returns the message keyed by preMsgHash, if it is held in any tier.
+func (q *ShardTxQueue) Lookup(preMsgHash common.Uint256) (*types.CrossShardMsg, bool) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	if entry, present := q.pendingIndex[preMsgHash]; present {
+		return entry.msg, true
+	}
+	if msg, present := q.queued[preMsgHash]; present {
+		return msg, true
+	}
+	if msg, present := q.confirmed[preMsgHash]; present {
+		return msg, true
+	}
+	return nil, false
+}
+
+// Stats reports pending/queued/confirmed sizes and the lifetime eviction
+// counters for each bounded tier.
+func (q *ShardTxQueue) Stats() QueueStats {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	return QueueStats{
+		Pending:       len(q.pendingIndex),
+		Queued:        len(q.queued),
+		Confirmed:     len(q.confirmed),
+		Evicted:       q.evicted,
+		QueuedEvicted: q.queuedEvicted,
+	}
+}
+
+// Content returns every message currently held, split by tier, for
+// diagnostics.
+func (q *ShardTxQueue) Content() QueueContent {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	content := QueueContent{
+		Pending:   make([]*types.CrossShardMsg, 0, len(q.pendingIndex)),
+		Queued:    make([]*types.CrossShardMsg, 0, len(q.queued)),
+		Confirmed: make([]*types.CrossShardMsg, 0, len(q.confirmed)),
+	}
+	for _, entry := range q.pendingIndex {
+		content.Pending = append(content.Pending, entry.msg)
+	}
+	for _, msg := range q.queued {
+		content.Queued = append(content.Queued, msg)
+	}
+	for _, msg := range q.confirmed {
+		content.Confirmed = append(content.Confirmed, msg)
+	}
+	return content
+}
+
+// Reinject handles a detected reorg: every pending or confirmed message at
+// or above fromHeight belonged to an abandoned fork, so it is moved back to
+// queued (instead of being dropped) to await re-linking once the new fork's
+// messages arrive. The chain head is rewound to fromHeight's predecessor so
+// Add can re-establish the link.
+func (q *ShardTxQueue) Reinject(fromHeight uint32, newHeadHash common.Uint256) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	remaining := make(pendingHeap, 0, len(q.pending))
+	for _, entry := range q.pending {
+		if entry.msg.CrossShardMsgInfo.MsgHeight >= fromHeight {
+			hash := entry.msg.CrossShardMsgInfo.PreCrossShardMsgHash
+			q.queued[hash] = entry.msg
+			q.queuedOrder = append(q.queuedOrder, hash)
+			delete(q.pendingIndex, hash)
+		} else {
+			remaining = append(remaining, entry)
+		}
+	}
+	heap.Init(&remaining)
+	q.pending = remaining
+
+	keptOrder := make([]common.Uint256, 0, len(q.confirmOrder))
+	for _, hash := range q.confirmOrder {
+		msg, present := q.confirmed[hash]
+		if !present {
+			continue
+		}
+		if msg.CrossShardMsgInfo.MsgHeight >= fromHeight {
+			delete(q.confirmed, hash)
+			q.queued[hash] = msg
+			q.queuedOrder = append(q.queuedOrder, hash)
+			continue
+		}
+		keptOrder = append(keptOrder, hash)
+	}
+	q.confirmOrder = keptOrder
+	q.evictQueuedLocked()
+
+	q.headHash = newHeadHash
+	q.hasTail = false
+	q.promoteLocked()
+}
@@ -0,0 +1,228 @@
+/*
+ * Copyright (C) 2019 The ontology Authors
+ * This file is part of The ontology library.
+ *
+ * The ontology is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The ontology is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public License
+ * along with The ontology.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package xshard
+
+import (
+	"crypto/sha256"
+	"testing"
+
+	"github.com/ontio/ontology/common"
+	"github.com/ontio/ontology/core/types"
+)
+
+func testHash(t testing.TB, seed string) common.Uint256 {
+	return common.Uint256(sha256.Sum256([]byte(seed)))
+}
+
+func testMsg(t testing.TB, height uint32, preHash, root common.Uint256) *types.CrossShardMsg {
+	return &types.CrossShardMsg{
+		CrossShardMsgInfo: &types.CrossShardMsgInfo{
+			MsgHeight:            height,
+			PreCrossShardMsgHash: preHash,
+			CrossShardMsgRoot:    root,
+		},
+	}
+}
+
+func TestShardTxQueueAddLinksOntoHead(t *testing.T) {
+	head := testHash(t, "head")
+	root1 := testHash(t, "root1")
+	q := newShardTxQueue(1, 0, head)
+
+	q.Add(testMsg(t, 1, head, root1))
+
+	stats := q.Stats()
+	if stats.Pending != 1 || stats.Queued != 0 {
+		t.Fatalf("Stats = %+v, want 1 pending, 0 queued", stats)
+	}
+}
+
+func TestShardTxQueueAddQueuesOutOfOrder(t *testing.T) {
+	head := testHash(t, "head")
+	root1 := testHash(t, "root1")
+	root2 := testHash(t, "root2")
+	q := newShardTxQueue(1, 0, head)
+
+	// msg2 does not link onto head yet, so it must be queued rather than
+	// pending until msg1 (which produces root1) arrives.
+	q.Add(testMsg(t, 2, root1, root2))
+	stats := q.Stats()
+	if stats.Pending != 0 || stats.Queued != 1 {
+		t.Fatalf("Stats after out-of-order add = %+v, want 0 pending, 1 queued", stats)
+	}
+
+	q.Add(testMsg(t, 1, head, root1))
+	stats = q.Stats()
+	if stats.Pending != 2 || stats.Queued != 0 {
+		t.Fatalf("Stats after promotion = %+v, want 2 pending, 0 queued", stats)
+	}
+
+	pending := q.Pending()
+	if len(pending) != 2 || pending[0].CrossShardMsgInfo.MsgHeight != 1 || pending[1].CrossShardMsgInfo.MsgHeight != 2 {
+		t.Fatalf("Pending() = %+v, want height 1 then height 2", pending)
+	}
+}
+
+func TestShardTxQueueAddIsIdempotent(t *testing.T) {
+	head := testHash(t, "head")
+	root1 := testHash(t, "root1")
+	q := newShardTxQueue(1, 0, head)
+
+	q.Add(testMsg(t, 1, head, root1))
+	q.Add(testMsg(t, 1, head, root1))
+
+	if stats := q.Stats(); stats.Pending != 1 {
+		t.Fatalf("Stats = %+v, want re-add to be a no-op", stats)
+	}
+}
+
+func TestShardTxQueueConfirmAdvancesHeadAndKeepsSuccessorPending(t *testing.T) {
+	head := testHash(t, "head")
+	root1 := testHash(t, "root1")
+	root2 := testHash(t, "root2")
+	q := newShardTxQueue(1, 0, head)
+
+	q.Add(testMsg(t, 1, head, root1))
+	q.Add(testMsg(t, 2, root1, root2))
+
+	q.Confirm(head)
+
+	stats := q.Stats()
+	if stats.Confirmed != 1 || stats.Pending != 1 {
+		t.Fatalf("Stats after Confirm = %+v, want 1 confirmed, 1 pending", stats)
+	}
+	if q.headHash != root1 {
+		t.Fatalf("headHash = %s, want %s", q.headHash.ToHexString(), root1.ToHexString())
+	}
+}
+
+func TestShardTxQueueConfirmEvictsOldestBeyondMaxCap(t *testing.T) {
+	head := testHash(t, "head")
+	root1 := testHash(t, "root1")
+	root2 := testHash(t, "root2")
+	q := newShardTxQueue(1, 1, head)
+
+	q.Add(testMsg(t, 1, head, root1))
+	q.Add(testMsg(t, 2, root1, root2))
+	q.Confirm(head)
+	q.Confirm(root1)
+
+	stats := q.Stats()
+	if stats.Confirmed != 1 {
+		t.Fatalf("Confirmed = %d, want 1 (bounded by maxCap)", stats.Confirmed)
+	}
+	if stats.Evicted != 1 {
+		t.Fatalf("Evicted = %d, want 1", stats.Evicted)
+	}
+	if _, present := q.Lookup(head); present {
+		t.Fatalf("Lookup(head): expected the oldest confirmed entry to have been evicted")
+	}
+	if _, present := q.Lookup(root1); !present {
+		t.Fatalf("Lookup(root1): expected the newest confirmed entry to still be held")
+	}
+}
+
+func TestShardTxQueueQueuedEvictsOldestBeyondMaxCap(t *testing.T) {
+	head := testHash(t, "head")
+	q := newShardTxQueue(1, 1, head)
+
+	// Neither of these links onto head, so both land in queued rather than
+	// pending; queued must bound itself the same way confirmed does.
+	q.Add(testMsg(t, 5, testHash(t, "unlinked1"), testHash(t, "r1")))
+	q.Add(testMsg(t, 6, testHash(t, "unlinked2"), testHash(t, "r2")))
+
+	stats := q.Stats()
+	if stats.Queued != 1 {
+		t.Fatalf("Queued = %d, want 1 (bounded by maxCap)", stats.Queued)
+	}
+	if stats.QueuedEvicted != 1 {
+		t.Fatalf("QueuedEvicted = %d, want 1", stats.QueuedEvicted)
+	}
+	if _, present := q.Lookup(testHash(t, "unlinked1")); present {
+		t.Fatalf("Lookup(unlinked1): expected the oldest queued entry to have been evicted")
+	}
+	if _, present := q.Lookup(testHash(t, "unlinked2")); !present {
+		t.Fatalf("Lookup(unlinked2): expected the newest queued entry to still be held")
+	}
+}
+
+func TestShardTxQueueLookupAcrossTiers(t *testing.T) {
+	head := testHash(t, "head")
+	root1 := testHash(t, "root1")
+	root2 := testHash(t, "root2")
+	q := newShardTxQueue(1, 0, head)
+
+	q.Add(testMsg(t, 1, head, root1))
+	q.Add(testMsg(t, 3, root2, testHash(t, "root3")))
+
+	if _, present := q.Lookup(head); !present {
+		t.Fatalf("Lookup(head): expected the pending entry to be found")
+	}
+	if _, present := q.Lookup(root2); !present {
+		t.Fatalf("Lookup(root2): expected the queued entry to be found")
+	}
+	if _, present := q.Lookup(testHash(t, "missing")); present {
+		t.Fatalf("Lookup(missing): expected no entry")
+	}
+}
+
+func TestShardTxQueueReinjectMovesAbandonedForkToQueued(t *testing.T) {
+	head := testHash(t, "head")
+	root1 := testHash(t, "root1")
+	root2 := testHash(t, "root2")
+	q := newShardTxQueue(1, 0, head)
+
+	q.Add(testMsg(t, 1, head, root1))
+	q.Confirm(head)
+	q.Add(testMsg(t, 2, root1, root2))
+
+	// A reorg at height 1 invalidates the confirmed height-1 message and the
+	// still-pending height-2 message, both of which should move to queued
+	// rather than being dropped.
+	newHead := testHash(t, "newhead")
+	q.Reinject(1, newHead)
+
+	stats := q.Stats()
+	if stats.Confirmed != 0 || stats.Pending != 0 || stats.Queued != 2 {
+		t.Fatalf("Stats after Reinject = %+v, want 0 confirmed, 0 pending, 2 queued", stats)
+	}
+
+	// Adding a message that links onto the rewound head should promote both
+	// queued messages back into pending behind it, in order.
+	q.Add(testMsg(t, 1, newHead, head))
+	stats = q.Stats()
+	if stats.Pending != 3 || stats.Queued != 0 {
+		t.Fatalf("Stats after re-linking = %+v, want 3 pending, 0 queued", stats)
+	}
+}
+
+func TestShardTxQueueContentMatchesStats(t *testing.T) {
+	head := testHash(t, "head")
+	root1 := testHash(t, "root1")
+	q := newShardTxQueue(1, 0, head)
+
+	q.Add(testMsg(t, 1, head, root1))
+	q.Add(testMsg(t, 3, testHash(t, "other"), testHash(t, "otherroot")))
+
+	content := q.Content()
+	stats := q.Stats()
+	if len(content.Pending) != stats.Pending || len(content.Queued) != stats.Queued || len(content.Confirmed) != stats.Confirmed {
+		t.Fatalf("Content() = %+v does not match Stats() = %+v", content, stats)
+	}
+}
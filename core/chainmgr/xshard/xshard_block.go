@@ -37,9 +37,19 @@ import (
 type CrossShardPool struct {
 	lock        sync.RWMutex
 	ShardID     common.ShardID
-	Shards      map[uint64]map[common.Uint256]*types.CrossShardMsg // key:indexed by FromShardID key:preMsgHash
+	Shards      map[uint64]*ShardTxQueue // key:indexed by FromShardID
 	MaxBlockCap uint32
 	ShardInfo   map[common.ShardID]bool
+
+	// HeaderChains holds a lightweight, signature-validated header chain per
+	// source shard, used to verify Merkle inclusion proofs on untrusted
+	// CrossShardMsg deliveries without syncing full source-shard state.
+	HeaderChains map[uint64]*HeaderChain
+	// Quarantine holds messages whose inclusion proof failed verification,
+	// keyed the same way as Shards, for later inspection instead of silent
+	// drop. Each bucket is bounded and evicts oldest-first, the same as
+	// ShardTxQueue's confirmed tier.
+	Quarantine map[uint64]*quarantineBucket
 }
 
 // BlockHeader and Cross-Shard Txs of other shards
@@ -47,11 +57,30 @@ var crossShardPool *CrossShardPool
 
 func InitCrossShardPool(shardID common.ShardID, historyCap uint32) {
 	crossShardPool = &CrossShardPool{
-		ShardID:     shardID,
-		Shards:      make(map[uint64]map[common.Uint256]*types.CrossShardMsg),
-		ShardInfo:   make(map[common.ShardID]bool),
-		MaxBlockCap: historyCap,
+		ShardID:      shardID,
+		Shards:       make(map[uint64]*ShardTxQueue),
+		ShardInfo:    make(map[common.ShardID]bool),
+		MaxBlockCap:  historyCap,
+		HeaderChains: make(map[uint64]*HeaderChain),
+		Quarantine:   make(map[uint64]*quarantineBucket),
+	}
+}
+
+// getOrCreateShardQueue returns the ShardTxQueue tracking messages from
+// fromShardID, creating it (seeded with the chain head already recorded in
+// ledger, if any) the first time that shard is seen.
+func (pool *CrossShardPool) getOrCreateShardQueue(lgr *ledger.Ledger, fromShardID common.ShardID) (*ShardTxQueue, error) {
+	id := fromShardID.ToUint64()
+	if queue, present := pool.Shards[id]; present {
+		return queue, nil
+	}
+	headHash, err := GetCrossShardHashByShardID(lgr, fromShardID)
+	if err != nil && err != com.ErrNotFound {
+		return nil, fmt.Errorf("getOrCreateShardQueue shardID:%v,err:%s", fromShardID, err)
 	}
+	queue := newShardTxQueue(id, pool.MaxBlockCap, headHash)
+	pool.Shards[id] = queue
+	return queue, nil
 }
 
 func InitShardInfo(lgr *ledger.Ledger) error {
@@ -74,6 +103,10 @@ func InitShardInfo(lgr *ledger.Ledger) error {
 				break
 			}
 		}
+		queue, err := pool.getOrCreateShardQueue(lgr, shardId)
+		if err != nil {
+			return err
+		}
 		for {
 			msg, err := lgr.GetCrossShardMsgByHash(msgHash)
 			if err != nil {
@@ -83,18 +116,7 @@ func InitShardInfo(lgr *ledger.Ledger) error {
 					break
 				}
 			}
-			if _, present := pool.Shards[shardId.ToUint64()]; !present {
-				pool.Shards[shardId.ToUint64()] = make(map[common.Uint256]*types.CrossShardMsg)
-			}
-			m := pool.Shards[shardId.ToUint64()]
-			if m == nil {
-				return fmt.Errorf("pool shard shardId:%v, nil map", shardId)
-			}
-			if _, present := m[msg.CrossShardMsgInfo.PreCrossShardMsgHash]; present {
-				log.Debugf("InitShardInfo msgHash:%s had exist", msgHash.ToHexString())
-				continue
-			}
-			m[msg.CrossShardMsgInfo.PreCrossShardMsgHash] = msg
+			queue.Add(msg)
 			msgHash = msg.CrossShardMsgInfo.CrossShardMsgRoot
 		}
 	}
@@ -137,20 +159,20 @@ func AddCrossShardInfo(lgr *ledger.Ledger, crossShardMsg *types.CrossShardMsg) e
 	pool := crossShardPool
 	pool.lock.Lock()
 	defer pool.lock.Unlock()
-	fromShardID := crossShardMsg.CrossShardMsgInfo.FromShardID.ToUint64()
-	if _, present := pool.Shards[fromShardID]; !present {
-		pool.Shards[fromShardID] = make(map[common.Uint256]*types.CrossShardMsg)
+	queue, err := pool.getOrCreateShardQueue(lgr, crossShardMsg.CrossShardMsgInfo.FromShardID)
+	if err != nil {
+		return err
 	}
-	m := pool.Shards[fromShardID]
-	if m == nil {
-		return fmt.Errorf("add shard cross shard msg:%d, nil map", fromShardID)
+	if _, present := queue.pendingIndex[crossShardMsg.CrossShardMsgInfo.PreCrossShardMsgHash]; present {
+		log.Debugf("SaveCrossShardMsgByShardID premsgHash:%s had save", crossShardMsg.CrossShardMsgInfo.PreCrossShardMsgHash.ToHexString())
+		return nil
 	}
-	if _, present := m[crossShardMsg.CrossShardMsgInfo.PreCrossShardMsgHash]; present {
+	if _, present := queue.queued[crossShardMsg.CrossShardMsgInfo.PreCrossShardMsgHash]; present {
 		log.Debugf("SaveCrossShardMsgByShardID premsgHash:%s had save", crossShardMsg.CrossShardMsgInfo.PreCrossShardMsgHash.ToHexString())
 		return nil
 	}
-	m[crossShardMsg.CrossShardMsgInfo.PreCrossShardMsgHash] = crossShardMsg
-	err := lgr.SaveCrossShardMsgByHash(crossShardMsg.CrossShardMsgInfo.PreCrossShardMsgHash, crossShardMsg)
+	queue.Add(crossShardMsg)
+	err = lgr.SaveCrossShardMsgByHash(crossShardMsg.CrossShardMsgInfo.PreCrossShardMsgHash, crossShardMsg)
 	if err != nil {
 		return fmt.Errorf("SaveCrossShardMsgByShardID shardID:%v,msgHash:%s,err:%s", crossShardMsg.CrossShardMsgInfo.FromShardID, crossShardMsg.CrossShardMsgInfo.PreCrossShardMsgHash.ToHexString(), err)
 	}
@@ -166,7 +188,7 @@ func AddCrossShardInfo(lgr *ledger.Ledger, crossShardMsg *types.CrossShardMsg) e
 		}
 	}
 	AddShardInfo(lgr, crossShardMsg.CrossShardMsgInfo.FromShardID)
-	log.Infof("chainmgr AddBlock from shard %d,msgHash:%v, block height %d", fromShardID, crossShardMsg.CrossShardMsgInfo.PreCrossShardMsgHash.ToHexString(), crossShardMsg.CrossShardMsgInfo.MsgHeight)
+	log.Infof("chainmgr AddBlock from shard %d,msgHash:%v, block height %d", crossShardMsg.CrossShardMsgInfo.FromShardID.ToUint64(), crossShardMsg.CrossShardMsgInfo.PreCrossShardMsgHash.ToHexString(), crossShardMsg.CrossShardMsgInfo.MsgHeight)
 	return nil
 }
 
@@ -225,7 +247,7 @@ func GetCrossShardTxs(lgr *ledger.Ledger, account *account.Account, FromShardID
 		}
 		crossShardMsgs := make([]*types.CrossShardMsg, 0)
 		for {
-			if shardMsg, persent := shardMsgs[msgHash]; !persent {
+			if shardMsg, present := shardMsgs.Lookup(msgHash); !present {
 				msg, err := lgr.GetCrossShardMsgByHash(msgHash)
 				if err != nil {
 					if err != com.ErrNotFound {
@@ -264,16 +286,71 @@ func DelCrossShardTxs(lgr *ledger.Ledger, crossShardTxs map[uint64][]*types.Cros
 	pool.lock.Lock()
 	defer pool.lock.Unlock()
 	for shardID, shardTxs := range crossShardTxs {
+		queue, present := pool.Shards[shardID]
+		if !present {
+			log.Infof("delcrossshardtxs shardID:%d,not exist", shardID)
+			continue
+		}
+		log.Infof("delcrossshardtxs shardID:%d", shardID)
 		for _, shardTx := range shardTxs {
-			if crossShardTxInfos, present := pool.Shards[shardID]; !present {
-				log.Infof("delcrossshardtxs shardID:%d,not exist", shardID)
-				return nil
-			} else {
-				log.Infof("delcrossshardtxs shardID:%d", shardID)
-				delete(crossShardTxInfos, shardTx.ShardMsg.CrossShardMsgRoot)
-				SaveCrossShardHash(lgr, common.NewShardIDUnchecked(shardID), shardTx.ShardMsg.PreCrossShardMsgHash)
-			}
+			queue.Confirm(shardTx.ShardMsg.PreCrossShardMsgHash)
+			SaveCrossShardHash(lgr, common.NewShardIDUnchecked(shardID), shardTx.ShardMsg.CrossShardMsgRoot)
 		}
 	}
 	return nil
-}
\ No newline at end of file
+}
+
+// Stats returns pending/queued/confirmed/evicted counters for fromShardID,
+// or zero values if that shard is not yet tracked.
+func Stats(fromShardID common.ShardID) QueueStats {
+	pool := crossShardPool
+	pool.lock.RLock()
+	defer pool.lock.RUnlock()
+	queue, present := pool.Shards[fromShardID.ToUint64()]
+	if !present {
+		return QueueStats{}
+	}
+	return queue.Stats()
+}
+
+// Pending returns the messages from fromShardID ready for inclusion, in
+// (MsgHeight, arrival) order.
+func Pending(fromShardID common.ShardID) []*types.CrossShardMsg {
+	pool := crossShardPool
+	pool.lock.RLock()
+	defer pool.lock.RUnlock()
+	queue, present := pool.Shards[fromShardID.ToUint64()]
+	if !present {
+		return nil
+	}
+	return queue.Pending()
+}
+
+// Content returns every message currently held for fromShardID, split by
+// tier, for diagnostics.
+func Content(fromShardID common.ShardID) QueueContent {
+	pool := crossShardPool
+	pool.lock.RLock()
+	defer pool.lock.RUnlock()
+	queue, present := pool.Shards[fromShardID.ToUint64()]
+	if !present {
+		return QueueContent{}
+	}
+	return queue.Content()
+}
+
+// Reinject handles a detected reorg on fromShardID: every message at or
+// above fromHeight is moved back to queued instead of being dropped, and the
+// chain head is rewound to newHeadHash so Add can re-establish the link once
+// the new fork's messages arrive.
+func Reinject(lgr *ledger.Ledger, fromShardID common.ShardID, fromHeight uint32, newHeadHash common.Uint256) error {
+	pool := crossShardPool
+	pool.lock.Lock()
+	defer pool.lock.Unlock()
+	queue, err := pool.getOrCreateShardQueue(lgr, fromShardID)
+	if err != nil {
+		return err
+	}
+	queue.Reinject(fromHeight, newHeadHash)
+	return SaveCrossShardHash(lgr, fromShardID, newHeadHash)
+}
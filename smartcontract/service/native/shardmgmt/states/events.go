@@ -25,6 +25,7 @@ import (
 	"github.com/ontio/ontology/core/types"
 	"io"
 
+	"github.com/ontio/ontology/common"
 	"github.com/ontio/ontology/common/serialization"
 	"github.com/ontio/ontology/smartcontract/service/native/shardmgmt/utils"
 )
@@ -35,6 +36,11 @@ const (
 	EVENT_SHARD_PEER_JOIN
 	EVENT_SHARD_ACTIVATED
 	EVENT_SHARD_PEER_LEAVE
+	EVENT_SHARD_GAS_DEPOSIT
+	EVENT_SHARD_GAS_WITHDRAW_REQ
+	EVENT_SHARD_GAS_WITHDRAW_PREPARED
+	EVENT_SHARD_GAS_WITHDRAW_CLAIM
+	EVENT_SHARD_GAS_WITHDRAW_DONE
 )
 
 type ShardMgmtEvent interface {
@@ -58,6 +64,26 @@ func (self *ImplSourceTargetShardID) GetTargetShardID() types.ShardID {
 	return self.ShardID
 }
 
+func (self *ImplSourceTargetShardID) Serialize(w io.Writer) error {
+	if err := self.SourceShardID.Serialize(w); err != nil {
+		return fmt.Errorf("serialize ImplSourceTargetShardID.SourceShardID: %s", err)
+	}
+	if err := self.ShardID.Serialize(w); err != nil {
+		return fmt.Errorf("serialize ImplSourceTargetShardID.ShardID: %s", err)
+	}
+	return nil
+}
+
+func (self *ImplSourceTargetShardID) Deserialize(r io.Reader) error {
+	if err := self.SourceShardID.Deserialize(r); err != nil {
+		return fmt.Errorf("deserialize ImplSourceTargetShardID.SourceShardID: %s", err)
+	}
+	if err := self.ShardID.Deserialize(r); err != nil {
+		return fmt.Errorf("deserialize ImplSourceTargetShardID.ShardID: %s", err)
+	}
+	return nil
+}
+
 type CreateShardEvent struct {
 	SourceShardID types.ShardID `json:"source_shard_id"`
 	Height        uint64        `json:"height"`
@@ -81,11 +107,31 @@ func (evt *CreateShardEvent) GetType() uint32 {
 }
 
 func (evt *CreateShardEvent) Serialize(w io.Writer) error {
-	return shardutil.SerJson(w, evt)
+	if err := evt.SourceShardID.Serialize(w); err != nil {
+		return fmt.Errorf("serialize CreateShardEvent.SourceShardID: %s", err)
+	}
+	if err := serialization.WriteUint64(w, evt.Height); err != nil {
+		return fmt.Errorf("serialize CreateShardEvent.Height: %s", err)
+	}
+	if err := evt.NewShardID.Serialize(w); err != nil {
+		return fmt.Errorf("serialize CreateShardEvent.NewShardID: %s", err)
+	}
+	return nil
 }
 
 func (evt *CreateShardEvent) Deserialize(r io.Reader) error {
-	return shardutil.DesJson(r, evt)
+	if err := evt.SourceShardID.Deserialize(r); err != nil {
+		return fmt.Errorf("deserialize CreateShardEvent.SourceShardID: %s", err)
+	}
+	height, err := serialization.ReadUint64(r)
+	if err != nil {
+		return fmt.Errorf("deserialize CreateShardEvent.Height: %s", err)
+	}
+	evt.Height = height
+	if err := evt.NewShardID.Deserialize(r); err != nil {
+		return fmt.Errorf("deserialize CreateShardEvent.NewShardID: %s", err)
+	}
+	return nil
 }
 
 type ConfigShardEvent struct {
@@ -103,11 +149,32 @@ func (evt *ConfigShardEvent) GetType() uint32 {
 }
 
 func (evt *ConfigShardEvent) Serialize(w io.Writer) error {
-	return shardutil.SerJson(w, evt)
+	if err := evt.ImplSourceTargetShardID.Serialize(w); err != nil {
+		return err
+	}
+	if err := serialization.WriteUint64(w, evt.Height); err != nil {
+		return fmt.Errorf("serialize ConfigShardEvent.Height: %s", err)
+	}
+	if err := evt.Config.Serialize(w); err != nil {
+		return fmt.Errorf("serialize ConfigShardEvent.Config: %s", err)
+	}
+	return nil
 }
 
 func (evt *ConfigShardEvent) Deserialize(r io.Reader) error {
-	return shardutil.DesJson(r, evt)
+	if err := evt.ImplSourceTargetShardID.Deserialize(r); err != nil {
+		return err
+	}
+	height, err := serialization.ReadUint64(r)
+	if err != nil {
+		return fmt.Errorf("deserialize ConfigShardEvent.Height: %s", err)
+	}
+	evt.Height = height
+	evt.Config = &ShardConfig{}
+	if err := evt.Config.Deserialize(r); err != nil {
+		return fmt.Errorf("deserialize ConfigShardEvent.Config: %s", err)
+	}
+	return nil
 }
 
 type PeerJoinShardEvent struct {
@@ -125,11 +192,33 @@ func (evt *PeerJoinShardEvent) GetType() uint32 {
 }
 
 func (evt *PeerJoinShardEvent) Serialize(w io.Writer) error {
-	return shardutil.SerJson(w, evt)
+	if err := evt.ImplSourceTargetShardID.Serialize(w); err != nil {
+		return err
+	}
+	if err := serialization.WriteUint64(w, evt.Height); err != nil {
+		return fmt.Errorf("serialize PeerJoinShardEvent.Height: %s", err)
+	}
+	if err := serialization.WriteString(w, evt.PeerPubKey); err != nil {
+		return fmt.Errorf("serialize PeerJoinShardEvent.PeerPubKey: %s", err)
+	}
+	return nil
 }
 
 func (evt *PeerJoinShardEvent) Deserialize(r io.Reader) error {
-	return shardutil.DesJson(r, evt)
+	if err := evt.ImplSourceTargetShardID.Deserialize(r); err != nil {
+		return err
+	}
+	height, err := serialization.ReadUint64(r)
+	if err != nil {
+		return fmt.Errorf("deserialize PeerJoinShardEvent.Height: %s", err)
+	}
+	evt.Height = height
+	pubKey, err := serialization.ReadString(r)
+	if err != nil {
+		return fmt.Errorf("deserialize PeerJoinShardEvent.PeerPubKey: %s", err)
+	}
+	evt.PeerPubKey = pubKey
+	return nil
 }
 
 type ShardActiveEvent struct {
@@ -146,11 +235,396 @@ func (evt *ShardActiveEvent) GetType() uint32 {
 }
 
 func (evt *ShardActiveEvent) Serialize(w io.Writer) error {
-	return shardutil.SerJson(w, evt)
+	if err := evt.ImplSourceTargetShardID.Serialize(w); err != nil {
+		return err
+	}
+	if err := serialization.WriteUint64(w, evt.Height); err != nil {
+		return fmt.Errorf("serialize ShardActiveEvent.Height: %s", err)
+	}
+	return nil
 }
 
 func (evt *ShardActiveEvent) Deserialize(r io.Reader) error {
-	return shardutil.DesJson(r, evt)
+	if err := evt.ImplSourceTargetShardID.Deserialize(r); err != nil {
+		return err
+	}
+	height, err := serialization.ReadUint64(r)
+	if err != nil {
+		return fmt.Errorf("deserialize ShardActiveEvent.Height: %s", err)
+	}
+	evt.Height = height
+	return nil
+}
+
+// DepositGasEvent records gas locked on a shard on behalf of a receiver on
+// ShardID, the first step of the withdraw lifecycle
+// (Deposit -> Req -> Prepared -> Claim -> Done).
+type DepositGasEvent struct {
+	ImplSourceTargetShardID
+	Height   uint64         `json:"height"`
+	Receiver common.Address `json:"receiver"`
+	Amount   uint64         `json:"amount"`
+}
+
+func (evt *DepositGasEvent) GetHeight() uint64 {
+	return evt.Height
+}
+
+func (evt *DepositGasEvent) GetType() uint32 {
+	return EVENT_SHARD_GAS_DEPOSIT
+}
+
+func (evt *DepositGasEvent) Serialize(w io.Writer) error {
+	if err := evt.ImplSourceTargetShardID.Serialize(w); err != nil {
+		return err
+	}
+	if err := serialization.WriteUint64(w, evt.Height); err != nil {
+		return fmt.Errorf("serialize DepositGasEvent.Height: %s", err)
+	}
+	if err := evt.Receiver.Serialize(w); err != nil {
+		return fmt.Errorf("serialize DepositGasEvent.Receiver: %s", err)
+	}
+	if err := serialization.WriteUint64(w, evt.Amount); err != nil {
+		return fmt.Errorf("serialize DepositGasEvent.Amount: %s", err)
+	}
+	return nil
+}
+
+func (evt *DepositGasEvent) Deserialize(r io.Reader) error {
+	if err := evt.ImplSourceTargetShardID.Deserialize(r); err != nil {
+		return err
+	}
+	height, err := serialization.ReadUint64(r)
+	if err != nil {
+		return fmt.Errorf("deserialize DepositGasEvent.Height: %s", err)
+	}
+	evt.Height = height
+	if err := evt.Receiver.Deserialize(r); err != nil {
+		return fmt.Errorf("deserialize DepositGasEvent.Receiver: %s", err)
+	}
+	amount, err := serialization.ReadUint64(r)
+	if err != nil {
+		return fmt.Errorf("deserialize DepositGasEvent.Amount: %s", err)
+	}
+	evt.Amount = amount
+	return nil
+}
+
+// WithdrawGasReqEvent is raised on the source shard when a receiver requests
+// to withdraw previously deposited gas back out to the parent. Nonce and
+// Expiry bound the claim that is later submitted on the parent shard so the
+// same request cannot be replayed or claimed after it has expired.
+type WithdrawGasReqEvent struct {
+	ImplSourceTargetShardID
+	Height   uint64         `json:"height"`
+	Receiver common.Address `json:"receiver"`
+	Amount   uint64         `json:"amount"`
+	Nonce    uint64         `json:"nonce"`
+	Expiry   uint64         `json:"expiry"`
+}
+
+func (evt *WithdrawGasReqEvent) GetHeight() uint64 {
+	return evt.Height
+}
+
+func (evt *WithdrawGasReqEvent) GetType() uint32 {
+	return EVENT_SHARD_GAS_WITHDRAW_REQ
+}
+
+func (evt *WithdrawGasReqEvent) Serialize(w io.Writer) error {
+	if err := evt.ImplSourceTargetShardID.Serialize(w); err != nil {
+		return err
+	}
+	if err := serialization.WriteUint64(w, evt.Height); err != nil {
+		return fmt.Errorf("serialize WithdrawGasReqEvent.Height: %s", err)
+	}
+	if err := evt.Receiver.Serialize(w); err != nil {
+		return fmt.Errorf("serialize WithdrawGasReqEvent.Receiver: %s", err)
+	}
+	if err := serialization.WriteUint64(w, evt.Amount); err != nil {
+		return fmt.Errorf("serialize WithdrawGasReqEvent.Amount: %s", err)
+	}
+	if err := serialization.WriteUint64(w, evt.Nonce); err != nil {
+		return fmt.Errorf("serialize WithdrawGasReqEvent.Nonce: %s", err)
+	}
+	if err := serialization.WriteUint64(w, evt.Expiry); err != nil {
+		return fmt.Errorf("serialize WithdrawGasReqEvent.Expiry: %s", err)
+	}
+	return nil
+}
+
+func (evt *WithdrawGasReqEvent) Deserialize(r io.Reader) error {
+	if err := evt.ImplSourceTargetShardID.Deserialize(r); err != nil {
+		return err
+	}
+	height, err := serialization.ReadUint64(r)
+	if err != nil {
+		return fmt.Errorf("deserialize WithdrawGasReqEvent.Height: %s", err)
+	}
+	evt.Height = height
+	if err := evt.Receiver.Deserialize(r); err != nil {
+		return fmt.Errorf("deserialize WithdrawGasReqEvent.Receiver: %s", err)
+	}
+	amount, err := serialization.ReadUint64(r)
+	if err != nil {
+		return fmt.Errorf("deserialize WithdrawGasReqEvent.Amount: %s", err)
+	}
+	evt.Amount = amount
+	nonce, err := serialization.ReadUint64(r)
+	if err != nil {
+		return fmt.Errorf("deserialize WithdrawGasReqEvent.Nonce: %s", err)
+	}
+	evt.Nonce = nonce
+	expiry, err := serialization.ReadUint64(r)
+	if err != nil {
+		return fmt.Errorf("deserialize WithdrawGasReqEvent.Expiry: %s", err)
+	}
+	evt.Expiry = expiry
+	return nil
+}
+
+// WithdrawGasPreparedEvent follows a WithdrawGasReqEvent once the source
+// shard has committed the withdrawal into its CrossShardMsgRoot. LockProof is
+// the Merkle inclusion proof a claimant submits on the parent shard to prove
+// the withdrawal was actually committed at MsgHeight.
+type WithdrawGasPreparedEvent struct {
+	ImplSourceTargetShardID
+	Height    uint64         `json:"height"`
+	Receiver  common.Address `json:"receiver"`
+	Amount    uint64         `json:"amount"`
+	Nonce     uint64         `json:"nonce"`
+	Expiry    uint64         `json:"expiry"`
+	MsgHeight uint32         `json:"msg_height"`
+	LockProof []byte         `json:"lock_proof"`
+}
+
+func (evt *WithdrawGasPreparedEvent) GetHeight() uint64 {
+	return evt.Height
+}
+
+func (evt *WithdrawGasPreparedEvent) GetType() uint32 {
+	return EVENT_SHARD_GAS_WITHDRAW_PREPARED
+}
+
+func (evt *WithdrawGasPreparedEvent) Serialize(w io.Writer) error {
+	if err := evt.ImplSourceTargetShardID.Serialize(w); err != nil {
+		return err
+	}
+	if err := serialization.WriteUint64(w, evt.Height); err != nil {
+		return fmt.Errorf("serialize WithdrawGasPreparedEvent.Height: %s", err)
+	}
+	if err := evt.Receiver.Serialize(w); err != nil {
+		return fmt.Errorf("serialize WithdrawGasPreparedEvent.Receiver: %s", err)
+	}
+	if err := serialization.WriteUint64(w, evt.Amount); err != nil {
+		return fmt.Errorf("serialize WithdrawGasPreparedEvent.Amount: %s", err)
+	}
+	if err := serialization.WriteUint64(w, evt.Nonce); err != nil {
+		return fmt.Errorf("serialize WithdrawGasPreparedEvent.Nonce: %s", err)
+	}
+	if err := serialization.WriteUint64(w, evt.Expiry); err != nil {
+		return fmt.Errorf("serialize WithdrawGasPreparedEvent.Expiry: %s", err)
+	}
+	if err := serialization.WriteUint32(w, evt.MsgHeight); err != nil {
+		return fmt.Errorf("serialize WithdrawGasPreparedEvent.MsgHeight: %s", err)
+	}
+	if err := serialization.WriteVarBytes(w, evt.LockProof); err != nil {
+		return fmt.Errorf("serialize WithdrawGasPreparedEvent.LockProof: %s", err)
+	}
+	return nil
+}
+
+func (evt *WithdrawGasPreparedEvent) Deserialize(r io.Reader) error {
+	if err := evt.ImplSourceTargetShardID.Deserialize(r); err != nil {
+		return err
+	}
+	height, err := serialization.ReadUint64(r)
+	if err != nil {
+		return fmt.Errorf("deserialize WithdrawGasPreparedEvent.Height: %s", err)
+	}
+	evt.Height = height
+	if err := evt.Receiver.Deserialize(r); err != nil {
+		return fmt.Errorf("deserialize WithdrawGasPreparedEvent.Receiver: %s", err)
+	}
+	amount, err := serialization.ReadUint64(r)
+	if err != nil {
+		return fmt.Errorf("deserialize WithdrawGasPreparedEvent.Amount: %s", err)
+	}
+	evt.Amount = amount
+	nonce, err := serialization.ReadUint64(r)
+	if err != nil {
+		return fmt.Errorf("deserialize WithdrawGasPreparedEvent.Nonce: %s", err)
+	}
+	evt.Nonce = nonce
+	expiry, err := serialization.ReadUint64(r)
+	if err != nil {
+		return fmt.Errorf("deserialize WithdrawGasPreparedEvent.Expiry: %s", err)
+	}
+	evt.Expiry = expiry
+	msgHeight, err := serialization.ReadUint32(r)
+	if err != nil {
+		return fmt.Errorf("deserialize WithdrawGasPreparedEvent.MsgHeight: %s", err)
+	}
+	evt.MsgHeight = msgHeight
+	lockProof, err := serialization.ReadVarBytes(r)
+	if err != nil {
+		return fmt.Errorf("deserialize WithdrawGasPreparedEvent.LockProof: %s", err)
+	}
+	evt.LockProof = lockProof
+	return nil
+}
+
+// WithdrawGasClaimEvent is submitted on the parent shard together with the
+// LockProof carried by WithdrawGasPreparedEvent; it is only accepted once the
+// proof verifies against the source shard's CrossShardMsgRoot at MsgHeight
+// and (SourceShardID, Nonce) has not already been spent.
+type WithdrawGasClaimEvent struct {
+	ImplSourceTargetShardID
+	Height    uint64         `json:"height"`
+	Receiver  common.Address `json:"receiver"`
+	Amount    uint64         `json:"amount"`
+	Nonce     uint64         `json:"nonce"`
+	Expiry    uint64         `json:"expiry"`
+	MsgHeight uint32         `json:"msg_height"`
+	LockProof []byte         `json:"lock_proof"`
+}
+
+func (evt *WithdrawGasClaimEvent) GetHeight() uint64 {
+	return evt.Height
+}
+
+func (evt *WithdrawGasClaimEvent) GetType() uint32 {
+	return EVENT_SHARD_GAS_WITHDRAW_CLAIM
+}
+
+func (evt *WithdrawGasClaimEvent) Serialize(w io.Writer) error {
+	if err := evt.ImplSourceTargetShardID.Serialize(w); err != nil {
+		return err
+	}
+	if err := serialization.WriteUint64(w, evt.Height); err != nil {
+		return fmt.Errorf("serialize WithdrawGasClaimEvent.Height: %s", err)
+	}
+	if err := evt.Receiver.Serialize(w); err != nil {
+		return fmt.Errorf("serialize WithdrawGasClaimEvent.Receiver: %s", err)
+	}
+	if err := serialization.WriteUint64(w, evt.Amount); err != nil {
+		return fmt.Errorf("serialize WithdrawGasClaimEvent.Amount: %s", err)
+	}
+	if err := serialization.WriteUint64(w, evt.Nonce); err != nil {
+		return fmt.Errorf("serialize WithdrawGasClaimEvent.Nonce: %s", err)
+	}
+	if err := serialization.WriteUint64(w, evt.Expiry); err != nil {
+		return fmt.Errorf("serialize WithdrawGasClaimEvent.Expiry: %s", err)
+	}
+	if err := serialization.WriteUint32(w, evt.MsgHeight); err != nil {
+		return fmt.Errorf("serialize WithdrawGasClaimEvent.MsgHeight: %s", err)
+	}
+	if err := serialization.WriteVarBytes(w, evt.LockProof); err != nil {
+		return fmt.Errorf("serialize WithdrawGasClaimEvent.LockProof: %s", err)
+	}
+	return nil
+}
+
+func (evt *WithdrawGasClaimEvent) Deserialize(r io.Reader) error {
+	if err := evt.ImplSourceTargetShardID.Deserialize(r); err != nil {
+		return err
+	}
+	height, err := serialization.ReadUint64(r)
+	if err != nil {
+		return fmt.Errorf("deserialize WithdrawGasClaimEvent.Height: %s", err)
+	}
+	evt.Height = height
+	if err := evt.Receiver.Deserialize(r); err != nil {
+		return fmt.Errorf("deserialize WithdrawGasClaimEvent.Receiver: %s", err)
+	}
+	amount, err := serialization.ReadUint64(r)
+	if err != nil {
+		return fmt.Errorf("deserialize WithdrawGasClaimEvent.Amount: %s", err)
+	}
+	evt.Amount = amount
+	nonce, err := serialization.ReadUint64(r)
+	if err != nil {
+		return fmt.Errorf("deserialize WithdrawGasClaimEvent.Nonce: %s", err)
+	}
+	evt.Nonce = nonce
+	expiry, err := serialization.ReadUint64(r)
+	if err != nil {
+		return fmt.Errorf("deserialize WithdrawGasClaimEvent.Expiry: %s", err)
+	}
+	evt.Expiry = expiry
+	msgHeight, err := serialization.ReadUint32(r)
+	if err != nil {
+		return fmt.Errorf("deserialize WithdrawGasClaimEvent.MsgHeight: %s", err)
+	}
+	evt.MsgHeight = msgHeight
+	lockProof, err := serialization.ReadVarBytes(r)
+	if err != nil {
+		return fmt.Errorf("deserialize WithdrawGasClaimEvent.LockProof: %s", err)
+	}
+	evt.LockProof = lockProof
+	return nil
+}
+
+// WithdrawGasDoneEvent finalizes the lifecycle: funds have been released to
+// Receiver on the parent shard and (SourceShardID, Nonce) is recorded spent.
+type WithdrawGasDoneEvent struct {
+	ImplSourceTargetShardID
+	Height   uint64         `json:"height"`
+	Receiver common.Address `json:"receiver"`
+	Amount   uint64         `json:"amount"`
+	Nonce    uint64         `json:"nonce"`
+}
+
+func (evt *WithdrawGasDoneEvent) GetHeight() uint64 {
+	return evt.Height
+}
+
+func (evt *WithdrawGasDoneEvent) GetType() uint32 {
+	return EVENT_SHARD_GAS_WITHDRAW_DONE
+}
+
+func (evt *WithdrawGasDoneEvent) Serialize(w io.Writer) error {
+	if err := evt.ImplSourceTargetShardID.Serialize(w); err != nil {
+		return err
+	}
+	if err := serialization.WriteUint64(w, evt.Height); err != nil {
+		return fmt.Errorf("serialize WithdrawGasDoneEvent.Height: %s", err)
+	}
+	if err := evt.Receiver.Serialize(w); err != nil {
+		return fmt.Errorf("serialize WithdrawGasDoneEvent.Receiver: %s", err)
+	}
+	if err := serialization.WriteUint64(w, evt.Amount); err != nil {
+		return fmt.Errorf("serialize WithdrawGasDoneEvent.Amount: %s", err)
+	}
+	if err := serialization.WriteUint64(w, evt.Nonce); err != nil {
+		return fmt.Errorf("serialize WithdrawGasDoneEvent.Nonce: %s", err)
+	}
+	return nil
+}
+
+func (evt *WithdrawGasDoneEvent) Deserialize(r io.Reader) error {
+	if err := evt.ImplSourceTargetShardID.Deserialize(r); err != nil {
+		return err
+	}
+	height, err := serialization.ReadUint64(r)
+	if err != nil {
+		return fmt.Errorf("deserialize WithdrawGasDoneEvent.Height: %s", err)
+	}
+	evt.Height = height
+	if err := evt.Receiver.Deserialize(r); err != nil {
+		return fmt.Errorf("deserialize WithdrawGasDoneEvent.Receiver: %s", err)
+	}
+	amount, err := serialization.ReadUint64(r)
+	if err != nil {
+		return fmt.Errorf("deserialize WithdrawGasDoneEvent.Amount: %s", err)
+	}
+	evt.Amount = amount
+	nonce, err := serialization.ReadUint64(r)
+	if err != nil {
+		return fmt.Errorf("deserialize WithdrawGasDoneEvent.Nonce: %s", err)
+	}
+	evt.Nonce = nonce
+	return nil
 }
 
 type ShardEventState struct {
@@ -161,37 +635,181 @@ type ShardEventState struct {
 	Payload    []byte        `json:"payload"`
 }
 
+// NewShardEventState builds the ShardEventState a native contract records
+// for evt, canonically encoding it via EncodeShardEvent so every
+// newly-recorded event is written in the binary envelope form; only
+// payloads written before this codec existed fall back to JSON on decode.
+func NewShardEventState(toShard types.ShardID, fromHeight uint64, evt ShardMgmtEvent) (*ShardEventState, error) {
+	payload, err := EncodeShardEvent(evt)
+	if err != nil {
+		return nil, fmt.Errorf("NewShardEventState: %s", err)
+	}
+	return &ShardEventState{
+		Version:    eventCodecVersion,
+		EventType:  evt.GetType(),
+		ToShard:    toShard,
+		FromHeight: fromHeight,
+		Payload:    payload,
+	}, nil
+}
+
+// eventCodecVersion is the current ShardMgmtEventEnvelope wire version. It is
+// bumped whenever a registered event type's binary layout changes in a way
+// that is not self-describing.
+const eventCodecVersion = 1
+
+// eventFactories backs RegisterEventType/DecodeShardEvent with a table
+// lookup, replacing the previous per-type switch.
+var eventFactories = map[uint32]func() ShardMgmtEvent{}
+
+// RegisterEventType associates an event type id with a constructor for its
+// zero value, so DecodeShardEvent can look it up instead of switching on id.
+func RegisterEventType(id uint32, factory func() ShardMgmtEvent) {
+	eventFactories[id] = factory
+}
+
+func init() {
+	RegisterEventType(EVENT_SHARD_CREATE, func() ShardMgmtEvent { return &CreateShardEvent{} })
+	RegisterEventType(EVENT_SHARD_CONFIG_UPDATE, func() ShardMgmtEvent { return &ConfigShardEvent{} })
+	RegisterEventType(EVENT_SHARD_PEER_JOIN, func() ShardMgmtEvent { return &PeerJoinShardEvent{} })
+	RegisterEventType(EVENT_SHARD_ACTIVATED, func() ShardMgmtEvent { return &ShardActiveEvent{} })
+	RegisterEventType(EVENT_SHARD_GAS_DEPOSIT, func() ShardMgmtEvent { return &DepositGasEvent{} })
+	RegisterEventType(EVENT_SHARD_GAS_WITHDRAW_REQ, func() ShardMgmtEvent { return &WithdrawGasReqEvent{} })
+	RegisterEventType(EVENT_SHARD_GAS_WITHDRAW_PREPARED, func() ShardMgmtEvent { return &WithdrawGasPreparedEvent{} })
+	RegisterEventType(EVENT_SHARD_GAS_WITHDRAW_CLAIM, func() ShardMgmtEvent { return &WithdrawGasClaimEvent{} })
+	RegisterEventType(EVENT_SHARD_GAS_WITHDRAW_DONE, func() ShardMgmtEvent { return &WithdrawGasDoneEvent{} })
+}
+
+// ShardMgmtEventEnvelope is the on-the-wire framing around an encoded
+// ShardMgmtEvent: its type (for dispatch), its codec version (for future
+// layout changes), and the type's own binary-encoded payload. The encoding
+// is deterministic (fixed field order, no maps), so it is safe to hash
+// directly into a CrossShardMsgRoot.
+type ShardMgmtEventEnvelope struct {
+	Type    uint32
+	Version uint32
+	Payload []byte
+}
+
+func (envelope *ShardMgmtEventEnvelope) Serialize(w io.Writer) error {
+	if err := serialization.WriteUint32(w, envelope.Type); err != nil {
+		return fmt.Errorf("serialize ShardMgmtEventEnvelope.Type: %s", err)
+	}
+	if err := serialization.WriteUint32(w, envelope.Version); err != nil {
+		return fmt.Errorf("serialize ShardMgmtEventEnvelope.Version: %s", err)
+	}
+	if err := serialization.WriteVarBytes(w, envelope.Payload); err != nil {
+		return fmt.Errorf("serialize ShardMgmtEventEnvelope.Payload: %s", err)
+	}
+	return nil
+}
+
+func (envelope *ShardMgmtEventEnvelope) Deserialize(r io.Reader) error {
+	evtType, err := serialization.ReadUint32(r)
+	if err != nil {
+		return fmt.Errorf("deserialize ShardMgmtEventEnvelope.Type: %s", err)
+	}
+	version, err := serialization.ReadUint32(r)
+	if err != nil {
+		return fmt.Errorf("deserialize ShardMgmtEventEnvelope.Version: %s", err)
+	}
+	payload, err := serialization.ReadVarBytes(r)
+	if err != nil {
+		return fmt.Errorf("deserialize ShardMgmtEventEnvelope.Payload: %s", err)
+	}
+	envelope.Type, envelope.Version, envelope.Payload = evtType, version, payload
+	return nil
+}
+
+// EncodeShardEvent canonically encodes evt as a versioned binary
+// ShardMgmtEventEnvelope, for writing new payloads to the wire / ledger.
+func EncodeShardEvent(evt ShardMgmtEvent) ([]byte, error) {
+	payload := new(bytes.Buffer)
+	if err := evt.Serialize(payload); err != nil {
+		return nil, fmt.Errorf("encode shard event type %d: %s", evt.GetType(), err)
+	}
+	envelope := &ShardMgmtEventEnvelope{
+		Type:    evt.GetType(),
+		Version: eventCodecVersion,
+		Payload: payload.Bytes(),
+	}
+	buf := new(bytes.Buffer)
+	if err := envelope.Serialize(buf); err != nil {
+		return nil, fmt.Errorf("encode shard event envelope type %d: %s", evt.GetType(), err)
+	}
+	return buf.Bytes(), nil
+}
+
+// DecodeShardEvent looks evtType up in the event registry and decodes
+// evtPayload into that type. It first tries the binary
+// ShardMgmtEventEnvelope codec; payloads written by older nodes, which are a
+// bare JSON object with no envelope framing, fall back to the legacy
+// shardutil.SerJson/json encoding.
 func DecodeShardEvent(evtType uint32, evtPayload []byte) (ShardMgmtEvent, error) {
-	switch evtType {
-	case EVENT_SHARD_GAS_DEPOSIT:
-		evt := &DepositGasEvent{}
-		if err := evt.Deserialize(bytes.NewBuffer(evtPayload)); err != nil {
-			return nil, fmt.Errorf("unmarshal gas deposit evt: %s", err)
-		}
-		return evt, nil
-	case EVENT_SHARD_GAS_WITHDRAW_REQ:
-		evt := &WithdrawGasReqEvent{}
-		if err := evt.Deserialize(bytes.NewBuffer(evtPayload)); err != nil {
-			return nil, fmt.Errorf("unmarshal gas withdraw req: %s", err)
+	factory, present := eventFactories[evtType]
+	if !present {
+		return nil, fmt.Errorf("unknown remote event type: %d", evtType)
+	}
+
+	envelope := &ShardMgmtEventEnvelope{}
+	if err := envelope.Deserialize(bytes.NewBuffer(evtPayload)); err == nil && envelope.Type == evtType {
+		evt := factory()
+		if err := evt.Deserialize(bytes.NewBuffer(envelope.Payload)); err != nil {
+			return nil, fmt.Errorf("unmarshal binary evt type %d: %s", evtType, err)
 		}
 		return evt, nil
-	case EVENT_SHARD_GAS_WITHDRAW_DONE:
-		// TODO
-		return nil, nil
 	}
 
-	return nil, fmt.Errorf("unknown remote event type: %d", evtType)
+	evt := factory()
+	if err := shardutil.DesJson(bytes.NewBuffer(evtPayload), evt); err != nil {
+		return nil, fmt.Errorf("unmarshal legacy json evt type %d: %s", evtType, err)
+	}
+	return evt, nil
 }
 
 type _CrossShardTx struct {
 	Txs [][]byte `json:"txs"`
 }
 
+func (txs *_CrossShardTx) Serialize(w io.Writer) error {
+	if err := serialization.WriteVarUint(w, uint64(len(txs.Txs))); err != nil {
+		return fmt.Errorf("serialize _CrossShardTx.len: %s", err)
+	}
+	for _, tx := range txs.Txs {
+		if err := serialization.WriteVarBytes(w, tx); err != nil {
+			return fmt.Errorf("serialize _CrossShardTx.tx: %s", err)
+		}
+	}
+	return nil
+}
+
+func (txs *_CrossShardTx) Deserialize(r io.Reader) error {
+	n, err := serialization.ReadVarUint(r, 0)
+	if err != nil {
+		return fmt.Errorf("deserialize _CrossShardTx.len: %s", err)
+	}
+	txs.Txs = make([][]byte, 0, n)
+	for i := uint64(0); i < n; i++ {
+		tx, err := serialization.ReadVarBytes(r)
+		if err != nil {
+			return fmt.Errorf("deserialize _CrossShardTx.tx: %s", err)
+		}
+		txs.Txs = append(txs.Txs, tx)
+	}
+	return nil
+}
+
+// DecodeShardCommonReqs decodes the binary-encoded _CrossShardTx payload
+// produced by the current codec, falling back to the legacy JSON encoding
+// for payloads written before the binary codec existed.
 func DecodeShardCommonReqs(payload []byte) ([]*CommonShardReq, error) {
 	txs := &_CrossShardTx{}
-	// FIXME: fix marshaling
-	if err := json.Unmarshal(payload, txs); err != nil {
-		return nil, fmt.Errorf("failed to unmarshal txs: %s", err)
+	if err := txs.Deserialize(bytes.NewBuffer(payload)); err != nil {
+		legacy := &_CrossShardTx{}
+		if jsonErr := json.Unmarshal(payload, legacy); jsonErr != nil {
+			return nil, fmt.Errorf("failed to unmarshal txs: %s", err)
+		}
+		txs = legacy
 	}
 
 	reqs := make([]*CommonShardReq, 0)
@@ -0,0 +1,136 @@
+/*
+ * Copyright (C) 2019 The ontology Authors
+ * This file is part of The ontology library.
+ *
+ * The ontology is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The ontology is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public License
+ * along with The ontology.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package shardstates
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/ontio/ontology/common"
+	"github.com/ontio/ontology/core/types"
+	"github.com/ontio/ontology/smartcontract/service/native/shardmgmt/utils"
+)
+
+func sampleEvents(t testing.TB) []ShardMgmtEvent {
+	shardA, err := types.NewShardID(1)
+	if err != nil {
+		t.Fatalf("types.NewShardID(1): %s", err)
+	}
+	shardB, err := types.NewShardID(2)
+	if err != nil {
+		t.Fatalf("types.NewShardID(2): %s", err)
+	}
+	src := ImplSourceTargetShardID{SourceShardID: shardA, ShardID: shardB}
+	return []ShardMgmtEvent{
+		&CreateShardEvent{SourceShardID: shardA, Height: 10, NewShardID: shardB},
+		&PeerJoinShardEvent{ImplSourceTargetShardID: src, Height: 11, PeerPubKey: "deadbeef"},
+		&ShardActiveEvent{ImplSourceTargetShardID: src, Height: 12},
+		&DepositGasEvent{ImplSourceTargetShardID: src, Height: 13, Receiver: common.ADDRESS_EMPTY, Amount: 100},
+		&WithdrawGasReqEvent{ImplSourceTargetShardID: src, Height: 14, Receiver: common.ADDRESS_EMPTY, Amount: 100, Nonce: 1, Expiry: 1000},
+		&WithdrawGasPreparedEvent{ImplSourceTargetShardID: src, Height: 15, Receiver: common.ADDRESS_EMPTY, Amount: 100, Nonce: 1, Expiry: 1000, MsgHeight: 5, LockProof: []byte("proof")},
+		&WithdrawGasClaimEvent{ImplSourceTargetShardID: src, Height: 16, Receiver: common.ADDRESS_EMPTY, Amount: 100, Nonce: 1, Expiry: 1000, MsgHeight: 5, LockProof: []byte("proof")},
+		&WithdrawGasDoneEvent{ImplSourceTargetShardID: src, Height: 17, Receiver: common.ADDRESS_EMPTY, Amount: 100, Nonce: 1},
+	}
+}
+
+// TestEventCodecRoundTrip checks that every event type in sampleEvents (all
+// registered types except ConfigShardEvent) survives
+// EncodeShardEvent -> DecodeShardEvent -> EncodeShardEvent with a byte-for-
+// byte identical encoding, i.e. the binary envelope is canonical.
+func TestEventCodecRoundTrip(t *testing.T) {
+	for _, evt := range sampleEvents(t) {
+		encoded, err := EncodeShardEvent(evt)
+		if err != nil {
+			t.Fatalf("EncodeShardEvent type %d: %s", evt.GetType(), err)
+		}
+		decoded, err := DecodeShardEvent(evt.GetType(), encoded)
+		if err != nil {
+			t.Fatalf("DecodeShardEvent type %d: %s", evt.GetType(), err)
+		}
+		reencoded, err := EncodeShardEvent(decoded)
+		if err != nil {
+			t.Fatalf("re-encode type %d: %s", evt.GetType(), err)
+		}
+		if !bytes.Equal(encoded, reencoded) {
+			t.Fatalf("type %d: round trip not canonical: %x != %x", evt.GetType(), encoded, reencoded)
+		}
+	}
+}
+
+// TestNewShardEventStateUsesBinaryCodec pins NewShardEventState to
+// EncodeShardEvent's output, so it cannot silently regress back to a
+// legacy-JSON payload.
+func TestNewShardEventStateUsesBinaryCodec(t *testing.T) {
+	evt := sampleEvents(t)[3] // DepositGasEvent
+	toShard, err := types.NewShardID(7)
+	if err != nil {
+		t.Fatalf("types.NewShardID(7): %s", err)
+	}
+	state, err := NewShardEventState(toShard, 42, evt)
+	if err != nil {
+		t.Fatalf("NewShardEventState: %s", err)
+	}
+	want, err := EncodeShardEvent(evt)
+	if err != nil {
+		t.Fatalf("EncodeShardEvent: %s", err)
+	}
+	if !bytes.Equal(state.Payload, want) {
+		t.Fatalf("ShardEventState.Payload = %x, want %x", state.Payload, want)
+	}
+	if state.EventType != evt.GetType() || state.Version != eventCodecVersion {
+		t.Fatalf("ShardEventState = %+v, want type %d version %d", state, evt.GetType(), eventCodecVersion)
+	}
+}
+
+// FuzzDecodeShardEvent checks that DecodeShardEvent never panics on
+// attacker-controlled (evtType, payload), whether the bytes parse as a
+// valid envelope, fall back to legacy JSON, or are rejected outright.
+func FuzzDecodeShardEvent(f *testing.F) {
+	for _, evt := range sampleEvents(f) {
+		encoded, err := EncodeShardEvent(evt)
+		if err != nil {
+			f.Fatalf("EncodeShardEvent: %s", err)
+		}
+		f.Add(evt.GetType(), encoded)
+	}
+	f.Fuzz(func(t *testing.T, evtType uint32, payload []byte) {
+		_, _ = DecodeShardEvent(evtType, payload)
+	})
+}
+
+func BenchmarkEncodeShardEventBinary(b *testing.B) {
+	evt := sampleEvents(b)[3]
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := EncodeShardEvent(evt); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkEncodeShardEventJSON(b *testing.B) {
+	evt := sampleEvents(b)[3].(*DepositGasEvent)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		buf := new(bytes.Buffer)
+		if err := shardutil.SerJson(buf, evt); err != nil {
+			b.Fatal(err)
+		}
+	}
+}